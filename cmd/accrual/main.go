@@ -0,0 +1,56 @@
+// Команда accrual — имитация системы расчёта начислений для локального запуска gophermart.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrualsim"
+	"github.com/and161185/loyalty/internal/middleware"
+	"go.uber.org/zap"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var cfg accrualsim.Config
+	runAddress := flag.String("a", "localhost:8081", "HTTP server address")
+	flag.IntVar(&cfg.RateLimit, "rate-limit", 0, "Requests per minute allowed for GET /api/orders/{number}, 0 disables the limit")
+	flag.BoolVar(&cfg.Deterministic, "deterministic", false, "Process orders immediately on registration")
+	flag.DurationVar(&cfg.MaxDelay, "max-delay", 10*time.Second, "Max time an order spends in REGISTERED and in PROCESSING")
+	flag.Uint64Var(&cfg.Seed, "seed", uint64(time.Now().UnixNano()), "Random seed for processing delays")
+	flag.Parse()
+
+	if addr := os.Getenv("RUN_ADDRESS"); addr != "" {
+		*runAddress = addr
+	}
+
+	logger := zap.Must(zap.NewProduction()).Sugar()
+	defer logger.Sync()
+
+	server := &http.Server{
+		Addr:    *runAddress,
+		Handler: middleware.LogMiddleware(logger)(accrualsim.New(cfg).Handler()),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("server error: %v", err)
+		}
+	}()
+	logger.Infof("accrual simulator listening on %s", *runAddress)
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error(err)
+	}
+}
@@ -16,16 +16,61 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// gophermart migrate up|down|status [flags]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		command := ""
+		if len(os.Args) > 2 {
+			command = os.Args[2]
+			os.Args = append(os.Args[:1], os.Args[3:]...)
+		}
+
+		config := config.NewConfig()
+		deps := deps.NewDependencies(config)
+
+		if err := runMigrate(ctx, command, config.DatabaseURI, os.Stdout); err != nil {
+			deps.Logger.Fatal(err)
+		}
+		return
+	}
+
 	config := config.NewConfig()
-	deps := deps.NewDependencies(config.Key)
+	deps := deps.NewDependencies(config)
 
-	storage, err := storage.NewPostgreStorage(ctx, config.DatabaseURI)
+	if err := config.Validate(); err != nil {
+		deps.Logger.Fatal(err)
+	}
+
+	store, err := newStore(ctx, config.DatabaseURI)
 	if err != nil {
 		deps.Logger.Fatal(err)
 	}
+	if config.DatabaseURI == "" {
+		deps.Logger.Warn("database URI is empty, using in-memory storage")
+	}
 
-	srv := server.NewServer(storage, storage, storage, config, deps)
+	srv := server.NewServer(store, store, store, store, store, store, config, deps)
 	if err := srv.Run(ctx); err != nil {
 		deps.Logger.Fatal(err)
 	}
 }
+
+type store interface {
+	server.UserStorage
+	server.OrderStorage
+	server.BalanceStorage
+	server.IdempotencyStorage
+	server.SessionStorage
+	server.LoginAttemptStorage
+}
+
+func newStore(ctx context.Context, databaseURI string) (store, error) {
+	if databaseURI == "" {
+		return storage.NewMemoryStorage(), nil
+	}
+
+	if path, ok := storage.SQLitePath(databaseURI); ok {
+		return newSQLiteStore(ctx, path)
+	}
+
+	return storage.NewPostgreStorage(ctx, databaseURI)
+}
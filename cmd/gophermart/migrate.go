@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/and161185/loyalty/internal/migrate"
+	"github.com/and161185/loyalty/internal/storage"
+)
+
+func runMigrate(ctx context.Context, command string, databaseURI string, out io.Writer) error {
+	if command != "up" && command != "down" && command != "status" {
+		return fmt.Errorf("usage: gophermart migrate up|down|status [-d DATABASE_URI]")
+	}
+
+	migrator, closeStore, err := connectMigrator(ctx, databaseURI)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	switch command {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		err := migrator.Down(ctx)
+		if errors.Is(err, migrate.ErrNoAppliedMigrations) {
+			fmt.Fprintln(out, "nothing to revert")
+			return nil
+		}
+		return err
+	default:
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range list {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = "applied at " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%04d %-30s %s\n", st.Version, st.Name, applied)
+		}
+		return nil
+	}
+}
+
+func connectMigrator(ctx context.Context, databaseURI string) (*migrate.Migrator, func(), error) {
+	if path, ok := storage.SQLitePath(databaseURI); ok {
+		return connectSQLiteMigrator(ctx, path)
+	}
+
+	store, err := storage.ConnectPostgres(ctx, databaseURI)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrator, err := store.Migrator()
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	return migrator, store.Close, nil
+}
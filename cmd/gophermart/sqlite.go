@@ -0,0 +1,27 @@
+//go:build sqlite
+
+package main
+
+import (
+	"context"
+
+	"github.com/and161185/loyalty/internal/migrate"
+	"github.com/and161185/loyalty/internal/storage"
+)
+
+func newSQLiteStore(ctx context.Context, path string) (store, error) {
+	return storage.NewSQLiteStorage(ctx, path)
+}
+
+func connectSQLiteMigrator(ctx context.Context, path string) (*migrate.Migrator, func(), error) {
+	store, err := storage.ConnectSQLite(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	migrator, err := store.Migrator()
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	return migrator, store.Close, nil
+}
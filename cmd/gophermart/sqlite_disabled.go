@@ -0,0 +1,21 @@
+//go:build !sqlite
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/and161185/loyalty/internal/migrate"
+)
+
+// SQLite требует cgo, поэтому по умолчанию не собирается; нужна сборка с -tags sqlite
+var errSQLiteDisabled = errors.New("sqlite: built without SQLite support, rebuild with -tags sqlite (requires cgo)")
+
+func newSQLiteStore(ctx context.Context, path string) (store, error) {
+	return nil, errSQLiteDisabled
+}
+
+func connectSQLiteMigrator(ctx context.Context, path string) (*migrate.Migrator, func(), error) {
+	return nil, nil, errSQLiteDisabled
+}
@@ -0,0 +1,179 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/and161185/loyalty/internal/metrics"
+)
+
+// ErrCircuitOpen — запрос не отправлялся: система начислений считается недоступной
+var ErrCircuitOpen = errors.New("accrual circuit breaker is open")
+
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerConfig struct {
+	// FailureThreshold — сколько ошибок подряд размыкают цепь
+	FailureThreshold int
+	// OpenTimeout — сколько цепь остаётся разомкнутой до пробного запроса
+	OpenTimeout time.Duration
+	// OnStateChange вызывается под блокировкой Breaker и не должен обращаться к нему
+	OnStateChange func(from, to BreakerState)
+}
+
+// Breaker — closed: запросы идут; open: запросы отклоняются до истечения OpenTimeout;
+// half-open: пропускается один пробный запрос, по его результату цепь замыкается или снова размыкается
+type Breaker struct {
+	mu       sync.Mutex
+	cfg      BreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(cfg BreakerConfig) *Breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 1
+	}
+	metrics.AccrualBreakerState.Set(StateClosed.String())
+	return &Breaker{cfg: cfg}
+}
+
+// State возвращает текущее состояние; разомкнутая цепь с истёкшим таймаутом считается half-open
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Allow решает, можно ли отправить запрос; после разрешённого запроса нужно вызвать Record
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(StateHalfOpen)
+	case StateClosed:
+		return nil
+	}
+
+	if b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// отменённый запрос ничего не говорит о системе начислений
+	if errors.Is(err, context.Canceled) {
+		b.probing = false
+		return
+	}
+
+	failed := isFailure(err)
+	if failed {
+		metrics.AccrualFailures.Add(1)
+	}
+
+	switch b.state {
+	case StateHalfOpen:
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(StateClosed)
+		}
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = time.Now()
+	b.failures = 0
+	metrics.AccrualBreakerOpens.Add(1)
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	metrics.AccrualBreakerState.Set(state.String())
+
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, state)
+	}
+}
+
+// isFailure — признак недоступности системы начислений; 204 и 429 означают, что она отвечает
+func isFailure(err error) bool {
+	var rateErr *RateLimitError
+	return err != nil && !errors.Is(err, ErrNotRegistered) && !errors.As(err, &rateErr)
+}
+
+type BreakerClient struct {
+	client  AccrualClient
+	breaker *Breaker
+}
+
+func NewBreakerClient(client AccrualClient, breaker *Breaker) *BreakerClient {
+	return &BreakerClient{client: client, breaker: breaker}
+}
+
+func (c *BreakerClient) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return OrderInfo{}, err
+	}
+
+	metrics.AccrualRequests.Add(1)
+	info, err := c.client.GetOrder(ctx, number)
+	c.breaker.Record(err)
+
+	return info, err
+}
@@ -0,0 +1,120 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	var transitions []string
+	b := NewBreaker(BreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      50 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+
+	serverErr := &ServerError{StatusCode: 500}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b.Record(serverErr)
+	}
+
+	// успешный ответ сбрасывает счётчик ошибок подряд
+	b.Allow()
+	b.Record(ErrNotRegistered)
+
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b.Record(serverErr)
+	}
+
+	if b.State() != StateOpen {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expected half-open, got %s", b.State())
+	}
+
+	// в half-open проходит только один пробный запрос
+	if err := b.Allow(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen for second probe, got %v", err)
+	}
+
+	b.Record(nil)
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("expected transitions %v, got %v", want, transitions)
+		}
+	}
+}
+
+func TestBreaker_FailedProbeReopens(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond})
+
+	b.Allow()
+	b.Record(errors.New("connection refused"))
+	time.Sleep(30 * time.Millisecond)
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b.Record(&DecodeError{Err: errors.New("bad json")})
+
+	if b.State() != StateOpen {
+		t.Fatalf("expected open after failed probe, got %s", b.State())
+	}
+}
+
+func TestBreaker_IgnoresRateLimitAndCancel(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	b.Allow()
+	b.Record(&RateLimitError{RetryAfter: time.Second})
+	b.Allow()
+	b.Record(context.Canceled)
+
+	if b.State() != StateClosed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+}
+
+func TestBreakerClient_ShortCircuits(t *testing.T) {
+	stub := &stubClient{err: &ServerError{StatusCode: 503}}
+	client := NewBreakerClient(stub, NewBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+
+	var serverErr *ServerError
+	if _, err := client.GetOrder(context.Background(), "1"); !errors.As(err, &serverErr) {
+		t.Fatalf("expected ServerError, got %v", err)
+	}
+	if _, err := client.GetOrder(context.Background(), "2"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if len(stub.calls) != 1 {
+		t.Errorf("expected one request to reach accrual, got %d", len(stub.calls))
+	}
+}
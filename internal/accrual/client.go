@@ -0,0 +1,128 @@
+// Package accrual — клиент системы расчёта начислений баллов.
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/and161185/loyalty/internal/model"
+)
+
+type OrderInfo struct {
+	Order   string            `json:"order"`
+	Status  model.OrderStatus `json:"status"`
+	Accrual *model.Points     `json:"accrual,omitempty"`
+}
+
+type AccrualClient interface {
+	// GetOrder возвращает расчёт по заказу; ошибки — ErrNotRegistered, *RateLimitError,
+	// *ServerError, *DecodeError или транспортные
+	GetOrder(ctx context.Context, number string) (OrderInfo, error)
+}
+
+type Config struct {
+	Address string
+	// Timeout ограничивает весь запрос вместе с чтением тела
+	Timeout time.Duration
+	// MaxIdleConns — сколько соединений с системой начислений держать открытыми между запросами
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+}
+
+type HTTPClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHTTPClient(cfg Config) *HTTPClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+
+	return &HTTPClient{
+		baseURL: strings.TrimRight(cfg.Address, "/"),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+	}
+}
+
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	endpoint := c.baseURL + "/api/orders/" + url.PathEscape(number)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return OrderInfo{}, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return OrderInfo{}, fmt.Errorf("send request: %w", err)
+	}
+	defer func() {
+		// дочитываем тело, чтобы соединение вернулось в пул
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodySize))
+		resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		var info OrderInfo
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return OrderInfo{}, &DecodeError{Err: err}
+		}
+		return info, nil
+
+	case resp.StatusCode == http.StatusNoContent:
+		return OrderInfo{}, ErrNotRegistered
+
+	case resp.StatusCode == http.StatusTooManyRequests:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		return OrderInfo{}, &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Message:    strings.TrimSpace(string(body)),
+		}
+
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return OrderInfo{}, &ServerError{StatusCode: resp.StatusCode}
+
+	default:
+		return OrderInfo{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+// maxBodySize — сколько байт ответа об ошибке имеет смысл читать
+const maxBodySize = 4 << 10
+
+// parseRetryAfter понимает оба формата заголовка: число секунд и HTTP-дату
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if sec, err := strconv.Atoi(value); err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+
+	return 0
+}
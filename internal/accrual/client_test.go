@@ -0,0 +1,143 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/model"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *HTTPClient {
+	t.Helper()
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return NewHTTPClient(Config{Address: ts.URL, Timeout: time.Second})
+}
+
+func TestGetOrder_OK(t *testing.T) {
+	accrual := model.Points(5050)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/orders/1234567890" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"order":   "1234567890",
+			"status":  "PROCESSED",
+			"accrual": accrual,
+		})
+	})
+
+	info, err := client.GetOrder(context.Background(), "1234567890")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if info.Status != model.Processed {
+		t.Errorf("expected status %s, got %s", model.Processed, info.Status)
+	}
+
+	if info.Accrual == nil || *info.Accrual != accrual {
+		t.Errorf("expected accrual %s, got %v", accrual, info.Accrual)
+	}
+}
+
+func TestGetOrder_NoContent(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, err := client.GetOrder(context.Background(), "1234567890")
+	if !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("expected ErrNotRegistered, got %v", err)
+	}
+}
+
+func TestGetOrder_TooManyRequests(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("No more than 10 requests per minute allowed\n"))
+	})
+
+	_, err := client.GetOrder(context.Background(), "1234567890")
+
+	var rateErr *RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rateErr.RetryAfter != time.Minute {
+		t.Errorf("expected retry after 1m, got %s", rateErr.RetryAfter)
+	}
+	if rateErr.Message != "No more than 10 requests per minute allowed" {
+		t.Errorf("unexpected message %q", rateErr.Message)
+	}
+}
+
+func TestGetOrder_ServerError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := client.GetOrder(context.Background(), "1234567890")
+
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected ServerError 502, got %v", err)
+	}
+}
+
+func TestGetOrder_DecodeError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{not json"))
+	})
+
+	_, err := client.GetOrder(context.Background(), "1234567890")
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+}
+
+func TestGetOrder_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(Config{Address: ts.URL, Timeout: 50 * time.Millisecond})
+
+	if _, err := client.GetOrder(context.Background(), "1234567890"); err == nil {
+		t.Fatal("expected timeout error, got nil")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second},
+		{"Wed, 01 Jan 2025 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package accrual
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotRegistered — система начислений ещё не знает о заказе (204)
+var ErrNotRegistered = errors.New("order is not registered in accrual system")
+
+// RateLimitError — система начислений ответила 429
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("accrual server error: %d", e.StatusCode)
+}
+
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode accrual response: %v", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,150 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter — token bucket, общий для всех воркеров. Кроме темпа запросов умеет ставить
+// всех на паузу, когда система начислений отвечает 429
+type Limiter struct {
+	mu          sync.Mutex
+	perMinute   int
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// NewLimiter создаёт лимитер на perMinute запросов в минуту; 0 — без ограничения,
+// пока система начислений сама не сообщит лимит
+func NewLimiter(perMinute int) *Limiter {
+	return &Limiter{perMinute: max(perMinute, 0), tokens: 1, last: time.Now()}
+}
+
+// Wait блокируется, пока не освободится место для запроса
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve(time.Now())
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve забирает токен и возвращает 0 либо возвращает, сколько ещё ждать
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	if l.perMinute == 0 {
+		return 0
+	}
+
+	// ёмкость ведра — один запрос: запросы идут равномерно, без всплесков в начале минуты
+	rate := float64(l.perMinute) / float64(time.Minute)
+	l.tokens = min(1, l.tokens+float64(now.Sub(l.last))*rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / rate)
+}
+
+// Pause останавливает все запросы на d
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if until := now.Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	// после паузы начинаем с пустого ведра
+	l.tokens = 0
+	l.last = now
+}
+
+func (l *Limiter) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.perMinute = max(perMinute, 0)
+}
+
+func (l *Limiter) Rate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.perMinute
+}
+
+// defaultRetryAfter — пауза после 429 без заголовка Retry-After
+const defaultRetryAfter = time.Second
+
+var rateLimitMessage = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// parseRateLimit достаёт лимит из тела ответа 429
+func parseRateLimit(message string) (int, bool) {
+	m := rateLimitMessage.FindStringSubmatch(message)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// LimitedClient пропускает запросы через общий лимитер и подстраивает его по ответам 429
+type LimitedClient struct {
+	client  AccrualClient
+	limiter *Limiter
+}
+
+func NewLimitedClient(client AccrualClient, limiter *Limiter) *LimitedClient {
+	return &LimitedClient{client: client, limiter: limiter}
+}
+
+func (c *LimitedClient) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return OrderInfo{}, err
+	}
+
+	info, err := c.client.GetOrder(ctx, number)
+
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		if n, ok := parseRateLimit(rateErr.Message); ok {
+			c.limiter.SetRate(n)
+		}
+		pause := rateErr.RetryAfter
+		if pause <= 0 {
+			pause = defaultRetryAfter
+		}
+		c.limiter.Pause(pause)
+	}
+
+	return info, err
+}
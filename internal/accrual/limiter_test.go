@@ -0,0 +1,126 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubClient struct {
+	mu    sync.Mutex
+	calls []time.Time
+	err   error
+}
+
+func (c *stubClient) GetOrder(ctx context.Context, number string) (OrderInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, time.Now())
+	err := c.err
+	c.err = nil
+	return OrderInfo{Order: number}, err
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	// 1200 в минуту — 50ms между запросами
+	l := NewLimiter(1200)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("expected at least 200ms for 5 requests, got %s", elapsed)
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := NewLimiter(0)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("unlimited limiter waited %s", elapsed)
+	}
+}
+
+func TestLimiter_WaitCancelled(t *testing.T) {
+	l := NewLimiter(0)
+	l.Pause(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestLimitedClient_RateLimitPausesEveryone(t *testing.T) {
+	stub := &stubClient{err: &RateLimitError{
+		RetryAfter: 150 * time.Millisecond,
+		Message:    "No more than 60 requests per minute allowed",
+	}}
+	limiter := NewLimiter(0)
+	client := NewLimitedClient(stub, limiter)
+	ctx := context.Background()
+
+	var rateErr *RateLimitError
+	if _, err := client.GetOrder(ctx, "1"); !errors.As(err, &rateErr) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+
+	if got := limiter.Rate(); got != 60 {
+		t.Errorf("expected rate 60, got %d", got)
+	}
+	limiter.SetRate(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.GetOrder(ctx, "2")
+		}()
+	}
+	wg.Wait()
+
+	first := stub.calls[0]
+	for _, call := range stub.calls[1:] {
+		if call.Sub(first) < 140*time.Millisecond {
+			t.Errorf("request sent %s after 429, expected pause of 150ms", call.Sub(first))
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		message string
+		want    int
+		ok      bool
+	}{
+		{"No more than 10 requests per minute allowed", 10, true},
+		{"No more than 0 requests per minute allowed", 0, false},
+		{"Too many requests", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRateLimit(tt.message)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRateLimit(%q) = %d, %v; want %d, %v", tt.message, got, ok, tt.want, tt.ok)
+		}
+	}
+}
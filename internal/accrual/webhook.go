@@ -0,0 +1,65 @@
+package accrual
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Заголовки обратного вызова системы начислений. Подпись — HMAC-SHA256 от "<timestamp>.<body>",
+// timestamp — unix-время в секундах; он входит в подпись, чтобы перехваченный запрос нельзя было повторить позже
+const (
+	SignatureHeader = "X-Accrual-Signature"
+	TimestampHeader = "X-Accrual-Timestamp"
+)
+
+const signaturePrefix = "sha256="
+
+// MaxClockSkew — насколько timestamp обратного вызова может расходиться с нашими часами
+const MaxClockSkew = 5 * time.Minute
+
+// Reversal — обратный вызов об отмене начисления, когда партнёр отменил покупку уже после расчёта
+type Reversal struct {
+	Order  string `json:"order"`
+	Reason string `json:"reason,omitempty"`
+}
+
+var (
+	ErrBadSignature = errors.New("invalid webhook signature")
+	ErrStaleWebhook = errors.New("webhook timestamp out of range")
+)
+
+// Sign возвращает значение заголовка SignatureHeader
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifySignature(secret []byte, timestamp, signature string, body []byte, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleWebhook
+	}
+
+	skew := now.Sub(time.Unix(sec, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return ErrStaleWebhook
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrBadSignature
+	}
+
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package accrual
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"order":"1","status":"PROCESSED"}`)
+	now := time.Unix(1_700_000_000, 0)
+	ts := "1700000000"
+	sig := Sign(secret, ts, body)
+
+	if err := VerifySignature(secret, ts, sig, body, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := VerifySignature(secret, ts, sig, []byte(`{"order":"1","status":"INVALID"}`), now); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for tampered body, got %v", err)
+	}
+
+	// подпись привязана к timestamp, подменить его нельзя
+	if err := VerifySignature(secret, "1700000001", sig, body, now); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for replaced timestamp, got %v", err)
+	}
+
+	if err := VerifySignature(secret, ts, sig, body, now.Add(MaxClockSkew+time.Second)); !errors.Is(err, ErrStaleWebhook) {
+		t.Errorf("expected ErrStaleWebhook, got %v", err)
+	}
+
+	if err := VerifySignature(secret, "yesterday", sig, body, now); !errors.Is(err, ErrStaleWebhook) {
+		t.Errorf("expected ErrStaleWebhook for malformed timestamp, got %v", err)
+	}
+}
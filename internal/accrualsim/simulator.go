@@ -0,0 +1,231 @@
+// Package accrualsim — имитация системы расчёта начислений для локальной разработки и e2e-тестов.
+package accrualsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type RewardType string
+
+const (
+	RewardPercent RewardType = "%"
+	RewardPoints  RewardType = "pt"
+)
+
+type Good struct {
+	Description string       `json:"description"`
+	Price       model.Points `json:"price"`
+}
+
+// Rule — правило вознаграждения: товары, в описании которых встречается Match,
+// приносят Reward процентов от цены или Reward баллов
+type Rule struct {
+	Match      string       `json:"match"`
+	Reward     model.Points `json:"reward"`
+	RewardType RewardType   `json:"reward_type"`
+}
+
+type Config struct {
+	// RateLimit — допустимое число запросов GET /api/orders/{number} в минуту, 0 — без ограничения
+	RateLimit int
+	// Deterministic — заказ рассчитывается сразу при регистрации; иначе проходит REGISTERED и PROCESSING
+	// за случайное время до MaxDelay
+	Deterministic bool
+	MaxDelay      time.Duration
+	Seed          uint64
+}
+
+type Simulator struct {
+	cfg Config
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	rules  []Rule
+	orders map[string]simOrder
+
+	windowStart time.Time
+	windowCount int
+}
+
+type simOrder struct {
+	accrual      *model.Points
+	processingAt time.Time
+	processedAt  time.Time
+}
+
+func New(cfg Config) *Simulator {
+	return &Simulator{
+		cfg:    cfg,
+		rng:    rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		orders: make(map[string]simOrder),
+	}
+}
+
+func (s *Simulator) Handler() http.Handler {
+	router := chi.NewRouter()
+	router.Post("/api/goods", s.RegisterRuleHandler)
+	router.Post("/api/orders", s.RegisterOrderHandler)
+	router.Get("/api/orders/{number}", s.GetOrderHandler)
+	return router
+}
+
+func (s *Simulator) RegisterRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if rule.Match == "" || rule.Reward <= 0 || (rule.RewardType != RewardPercent && rule.RewardType != RewardPoints) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.rules {
+		if existing.Match == rule.Match {
+			http.Error(w, "rule already registered", http.StatusConflict)
+			return
+		}
+	}
+	s.rules = append(s.rules, rule)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Simulator) RegisterOrderHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Order string `json:"order"`
+		Goods []Good `json:"goods"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !utils.IsValidLuhn(req.Order) || len(req.Goods) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[req.Order]; ok {
+		http.Error(w, "order already registered", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	o := simOrder{accrual: s.calculate(req.Goods), processingAt: now, processedAt: now}
+	if !s.cfg.Deterministic && s.cfg.MaxDelay > 0 {
+		o.processingAt = now.Add(s.randomDelay())
+		o.processedAt = o.processingAt.Add(s.randomDelay())
+	}
+	s.orders[req.Order] = o
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Simulator) GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	s.mu.Lock()
+	retryAfter, limited := s.throttle(time.Now())
+	o, ok := s.orders[number]
+	s.mu.Unlock()
+
+	if limited {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "No more than %d requests per minute allowed", s.cfg.RateLimit)
+		return
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	info := accrual.OrderInfo{Order: number}
+	now := time.Now()
+	switch {
+	case now.Before(o.processingAt):
+		info.Status = model.Registered
+	case now.Before(o.processedAt):
+		info.Status = model.Processing
+	default:
+		info.Status = model.Processed
+		info.Accrual = o.accrual
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// throttle считает запросы в окне длиной в минуту и возвращает, сколько ждать до его конца
+func (s *Simulator) throttle(now time.Time) (time.Duration, bool) {
+	if s.cfg.RateLimit <= 0 {
+		return 0, false
+	}
+
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.windowCount = 0
+	}
+
+	s.windowCount++
+	if s.windowCount <= s.cfg.RateLimit {
+		return 0, false
+	}
+
+	return s.windowStart.Add(time.Minute).Sub(now), true
+}
+
+// calculate применяет к каждому товару первое подходящее правило; nil — начислять нечего
+func (s *Simulator) calculate(goods []Good) *model.Points {
+	var total model.Points
+	matched := false
+
+	for _, good := range goods {
+		for _, rule := range s.rules {
+			if !strings.Contains(good.Description, rule.Match) {
+				continue
+			}
+
+			matched = true
+			switch rule.RewardType {
+			case RewardPercent:
+				// цена и процент — в сотых, округляем половину вверх
+				total += model.Points((int64(good.Price)*int64(rule.Reward) + 5000) / 10000)
+			case RewardPoints:
+				total += rule.Reward
+			}
+			break
+		}
+	}
+
+	if !matched {
+		return nil
+	}
+	return &total
+}
+
+func (s *Simulator) randomDelay() time.Duration {
+	return time.Duration(s.rng.Int64N(int64(s.cfg.MaxDelay) + 1))
+}
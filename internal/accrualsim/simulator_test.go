@@ -0,0 +1,81 @@
+package accrualsim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func do(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestSimulator_Deterministic(t *testing.T) {
+	h := New(Config{Deterministic: true}).Handler()
+
+	require.Equal(t, http.StatusOK, do(t, h, http.MethodPost, "/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`).Code)
+	require.Equal(t, http.StatusOK, do(t, h, http.MethodPost, "/api/goods", `{"match":"Ложка","reward":5,"reward_type":"pt"}`).Code)
+	require.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/api/goods", `{"match":"Bork","reward":1,"reward_type":"pt"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/api/goods", `{"match":"Tefal","reward":1,"reward_type":"x"}`).Code)
+
+	order := `{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000.5},{"description":"Ложка","price":100}]}`
+	require.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/api/orders", order).Code)
+	require.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/api/orders", order).Code)
+	require.Equal(t, http.StatusBadRequest, do(t, h, http.MethodPost, "/api/orders", `{"order":"12345678900","goods":[{"description":"x","price":1}]}`).Code)
+
+	w := do(t, h, http.MethodGet, "/api/orders/12345678903", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var info accrual.OrderInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.Equal(t, model.Processed, info.Status)
+	require.NotNil(t, info.Accrual)
+	// 10% от 7000.50 = 700.05 и 5 баллов за ложку
+	require.Equal(t, model.Points(70505), *info.Accrual)
+
+	require.Equal(t, http.StatusNoContent, do(t, h, http.MethodGet, "/api/orders/2377225624", "").Code)
+}
+
+func TestSimulator_NoMatchingRule(t *testing.T) {
+	h := New(Config{Deterministic: true}).Handler()
+
+	require.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/api/orders", `{"order":"12345678903","goods":[{"description":"Стол","price":100}]}`).Code)
+
+	w := do(t, h, http.MethodGet, "/api/orders/12345678903", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), "accrual")
+}
+
+func TestSimulator_Delayed(t *testing.T) {
+	h := New(Config{MaxDelay: time.Hour, Seed: 1}).Handler()
+
+	require.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/api/orders", `{"order":"12345678903","goods":[{"description":"Стол","price":100}]}`).Code)
+
+	var info accrual.OrderInfo
+	require.NoError(t, json.NewDecoder(do(t, h, http.MethodGet, "/api/orders/12345678903", "").Body).Decode(&info))
+	require.Contains(t, []model.OrderStatus{model.Registered, model.Processing}, info.Status)
+}
+
+func TestSimulator_RateLimit(t *testing.T) {
+	h := New(Config{Deterministic: true, RateLimit: 2}).Handler()
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusNoContent, do(t, h, http.MethodGet, "/api/orders/12345678903", "").Code)
+	}
+
+	w := do(t, h, http.MethodGet, "/api/orders/12345678903", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+	require.Equal(t, "No more than 2 requests per minute allowed", w.Body.String())
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultKeyID — kid единственного ключа, заданного одной строкой секрета
+const DefaultKeyID = "default"
+
+// MinSecretLen — меньше 256 бит для HS256 не принимаем
+const MinSecretLen = 32
+
+// minRSABits — RSA-ключи короче 2048 бит не принимаем
+const minRSABits = 2048
+
+// Keyring — ключи подписи токенов. Подписывается всё активным ключом, проверяются токены любым
+// из ключей по kid из заголовка; ключ выводится из оборота удалением из списка
+type Keyring struct {
+	active string
+	keys   map[string]signingKey
+}
+
+type signingKey struct {
+	method jwt.SigningMethod
+	sign   any // nil у ключа, которым только проверяют
+	verify any
+}
+
+// SingleKeyring — связка из одного HMAC-ключа с kid DefaultKeyID
+func SingleKeyring(secret string) *Keyring {
+	return &Keyring{
+		active: DefaultKeyID,
+		keys:   map[string]signingKey{DefaultKeyID: hmacKey([]byte(secret))},
+	}
+}
+
+// ParseKeyring разбирает список ключей через запятую или перевод строки; первый ключ активный.
+// Запись "kid:secret" — HMAC-секрет для HS256, "kid:@path" — PEM-файл с ключом RSA (RS256) или Ed25519 (EdDSA):
+// закрытым, если ключом подписывают, или открытым, если им только проверяют выведенные из оборота токены.
+// Пустые строки и строки, начинающиеся с #, пропускаются
+func ParseKeyring(spec string) (*Keyring, error) {
+	return parseKeyring(spec, "")
+}
+
+// LoadKeyringFile читает ключи из файла в формате ParseKeyring, по одному на строку;
+// относительные пути к PEM-файлам считаются от каталога этого файла
+func LoadKeyringFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keys file: %w", err)
+	}
+	return parseKeyring(string(data), filepath.Dir(path))
+}
+
+func parseKeyring(spec, baseDir string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]signingKey)}
+
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		kid, value, ok := strings.Cut(entry, ":")
+		kid = strings.TrimSpace(kid)
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("key entry must be kid:secret or kid:@path")
+		}
+		if _, dup := kr.keys[kid]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+
+		var key signingKey
+		if path, isFile := strings.CutPrefix(value, "@"); isFile {
+			if !filepath.IsAbs(path) && baseDir != "" {
+				path = filepath.Join(baseDir, path)
+			}
+			var err error
+			if key, err = loadPEMKey(path); err != nil {
+				return nil, fmt.Errorf("key %q: %w", kid, err)
+			}
+		} else {
+			if len(value) < MinSecretLen {
+				return nil, fmt.Errorf("key %q: secret shorter than %d bytes", kid, MinSecretLen)
+			}
+			key = hmacKey([]byte(value))
+		}
+
+		if kr.active == "" {
+			if key.sign == nil {
+				return nil, fmt.Errorf("key %q: active key must be a private key", kid)
+			}
+			kr.active = kid
+		}
+		kr.keys[kid] = key
+	}
+
+	if kr.active == "" {
+		return nil, errors.New("no signing keys")
+	}
+
+	return kr, nil
+}
+
+func hmacKey(secret []byte) signingKey {
+	return signingKey{method: jwt.SigningMethodHS256, sign: secret, verify: secret}
+}
+
+func loadPEMKey(path string) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return signingKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return signingKey{}, fmt.Errorf("parse key: %w", err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return signingKey{}, fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+		}
+		return signingKey{method: jwt.SigningMethodRS256, sign: k, verify: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return signingKey{}, fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+		}
+		return signingKey{method: jwt.SigningMethodRS256, verify: k}, nil
+	case ed25519.PrivateKey:
+		return signingKey{method: jwt.SigningMethodEdDSA, sign: k, verify: k.Public()}, nil
+	case ed25519.PublicKey:
+		return signingKey{method: jwt.SigningMethodEdDSA, verify: k}, nil
+	default:
+		return signingKey{}, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+func (k *Keyring) activeKey() (string, signingKey) {
+	return k.active, k.keys[k.active]
+}
+
+func (k *Keyring) lookup(kid string) (signingKey, bool) {
+	key, ok := k.keys[kid]
+	return key, ok
+}
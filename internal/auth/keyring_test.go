@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	oldSecret = strings.Repeat("o", 32)
+	newSecret = strings.Repeat("n", 32)
+)
+
+func TestParseKeyring(t *testing.T) {
+	kr, err := ParseKeyring("2025-07:" + newSecret + ", 2025-01:" + oldSecret)
+	require.NoError(t, err)
+	require.Equal(t, "2025-07", kr.ActiveID())
+
+	_, ok := kr.lookup("2025-01")
+	require.True(t, ok)
+
+	tests := map[string]string{
+		"empty":     "",
+		"no kid":    ":" + newSecret,
+		"no secret": "k1",
+		"short":     "k1:short",
+		"duplicate": "k1:" + newSecret + ",k1:" + oldSecret,
+	}
+	for name, spec := range tests {
+		_, err := ParseKeyring(spec)
+		require.Error(t, err, name)
+	}
+}
+
+func TestLoadKeyringFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# активный ключ первым\n2025-07:" + newSecret + "\n\n2025-01:" + oldSecret + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	kr, err := LoadKeyringFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "2025-07", kr.ActiveID())
+
+	_, err = LoadKeyringFile(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	before, err := ParseKeyring("2025-01:" + oldSecret)
+	require.NoError(t, err)
+	oldToken, err := NewKeyringTokenManager(before, TokenConfig{AccessTTL: time.Hour}).GenerateToken(7, 0)
+	require.NoError(t, err)
+
+	// новый ключ подписывает, старый ещё принимается
+	during, err := ParseKeyring("2025-07:" + newSecret + ",2025-01:" + oldSecret)
+	require.NoError(t, err)
+	tm := NewKeyringTokenManager(during, TokenConfig{AccessTTL: time.Hour})
+
+	claims, err := tm.ParseToken(oldToken)
+	require.NoError(t, err)
+	require.Equal(t, 7, claims.UserID)
+
+	newToken, err := tm.GenerateToken(7, 0)
+	require.NoError(t, err)
+
+	// старый ключ выведен из оборота
+	after, err := ParseKeyring("2025-07:" + newSecret)
+	require.NoError(t, err)
+	tm = NewKeyringTokenManager(after, TokenConfig{AccessTTL: time.Hour})
+
+	_, err = tm.ParseToken(oldToken)
+	require.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	_, err = tm.ParseToken(newToken)
+	require.NoError(t, err)
+}
+
+// writePEM сохраняет закрытый ключ и его открытую часть в PKCS#8/PKIX
+func writePEM(t *testing.T, dir, name string, key crypto.Signer) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	der, err = x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pub.pem"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	writePEM(t, dir, "ed", edKey)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, dir, "rsa", rsaKey)
+
+	keysFile := filepath.Join(dir, "keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte("ed:@ed.pem\nrsa:@rsa.pub.pem\nhs:"+newSecret+"\n"), 0o600))
+
+	kr, err := LoadKeyringFile(keysFile)
+	require.NoError(t, err)
+	tm := NewKeyringTokenManager(kr, TokenConfig{AccessTTL: time.Hour, Issuer: "gophermart", Audience: "loyalty"})
+
+	token, err := tm.GenerateToken(5, 0)
+	require.NoError(t, err)
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "EdDSA", parsed.Method.Alg())
+	require.Equal(t, "ed", parsed.Header["kid"])
+
+	claims, err := tm.ParseToken(token)
+	require.NoError(t, err)
+	require.Equal(t, 5, claims.UserID)
+
+	// токен, подписанный ещё закрытым RSA-ключом, проверяется по открытому
+	rsaToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Subject:   "6",
+		Issuer:    "gophermart",
+		Audience:  jwt.ClaimStrings{"loyalty"},
+		ID:        "rsa-token",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	rsaToken.Header["kid"] = "rsa"
+	rsaSigned, err := rsaToken.SignedString(rsaKey)
+	require.NoError(t, err)
+
+	claims, err = tm.ParseToken(rsaSigned)
+	require.NoError(t, err)
+	require.Equal(t, 6, claims.UserID)
+
+	// HS256 с kid асимметричного ключа не проходит, даже если подписать открытым ключом как секретом
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	confused.Header["kid"] = "rsa"
+	pubPEM, err := os.ReadFile(filepath.Join(dir, "rsa.pub.pem"))
+	require.NoError(t, err)
+	confusedSigned, err := confused.SignedString(pubPEM)
+	require.NoError(t, err)
+	_, err = tm.ParseToken(confusedSigned)
+	require.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	jwks := tm.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, JWK{
+		Kty: "OKP", Kid: "ed", Use: "sig", Alg: "EdDSA", Crv: "Ed25519",
+		X: base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+	}, jwks.Keys[0])
+	require.Equal(t, "rsa", jwks.Keys[1].Kid)
+	require.Equal(t, "RS256", jwks.Keys[1].Alg)
+	require.Equal(t, "AQAB", jwks.Keys[1].E)
+
+	// открытым ключом подписывать нельзя
+	_, err = ParseKeyring("rsa:@" + filepath.Join(dir, "rsa.pub.pem"))
+	require.Error(t, err)
+}
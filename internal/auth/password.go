@@ -0,0 +1,91 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/and161185/loyalty/internal/errs"
+)
+
+// maxPasswordBytes — bcrypt учитывает только первые 72 байта, более длинный пароль не принимаем
+const maxPasswordBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+var commonPasswords = parseCommonPasswords(commonPasswordsList)
+
+func parseCommonPasswords(list string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
+
+// PasswordPolicy — требования к новому паролю. Нулевое значение требует только непустой пароль
+// не длиннее 72 байт
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses — сколько разных классов символов должно быть в пароле:
+	// строчные и заглавные буквы, цифры, остальные символы
+	MinClasses int
+	// RejectCommon — отвергать пароли из встроенного списка распространённых и совпадающие с логином
+	RejectCommon bool
+}
+
+// Validate проверяет пароль пользователя login; ошибка оборачивает errs.ErrWeakPassword
+// и объясняет, какое требование не выполнено
+func (p PasswordPolicy) Validate(login, password string) error {
+	if password == "" {
+		return fmt.Errorf("%w: password is empty", errs.ErrWeakPassword)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: password is longer than %d bytes", errs.ErrWeakPassword, maxPasswordBytes)
+	}
+	if n := len([]rune(password)); n < p.MinLength {
+		return fmt.Errorf("%w: password must be at least %d characters", errs.ErrWeakPassword, p.MinLength)
+	}
+	if n := characterClasses(password); n < p.MinClasses {
+		return fmt.Errorf("%w: password must mix at least %d of lowercase, uppercase, digits and symbols", errs.ErrWeakPassword, p.MinClasses)
+	}
+	if p.RejectCommon {
+		if strings.EqualFold(password, login) {
+			return fmt.Errorf("%w: password must differ from login", errs.ErrWeakPassword)
+		}
+		if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+			return fmt.Errorf("%w: password is too common", errs.ErrWeakPassword)
+		}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MinClasses: 2, RejectCommon: true}
+
+	tests := map[string]struct {
+		password string
+		ok       bool
+	}{
+		"valid":           {"correct-horse", true},
+		"unicode length":  {"пароль-42", true},
+		"empty":           {"", false},
+		"too short":       {"ab-12", false},
+		"one class":       {"abcdefghij", false},
+		"common":          {"password1", false},
+		"common any case": {"PassWord1", false},
+		"same as login":   {"Alice-2024", false},
+		"too long":        {strings.Repeat("a1", 37), false},
+	}
+
+	for name, tt := range tests {
+		err := policy.Validate("alice-2024", tt.password)
+		if tt.ok {
+			require.NoError(t, err, name)
+		} else {
+			require.ErrorIs(t, err, errs.ErrWeakPassword, name)
+		}
+	}
+
+	// нулевая политика пропускает любой непустой пароль
+	require.NoError(t, PasswordPolicy{}.Validate("alice", "password"))
+}
@@ -1,52 +1,158 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/and161185/loyalty/internal/errs"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// DefaultAccessTTL — время жизни access-токена; короткое, потому что отозвать его можно
+// только по jti или сменой пароля, а продлевается сессия refresh-токеном
+const DefaultAccessTTL = 15 * time.Minute
+
+// DefaultIssuer — iss и aud токенов, если другие не заданы
+const DefaultIssuer = "gophermart"
+
 type TokenManager struct {
-	secretKey []byte
+	keys *Keyring
+	cfg  TokenConfig
+}
+
+type TokenConfig struct {
+	AccessTTL time.Duration
+	Issuer    string
+	Audience  string
+}
+
+// Claims — проверенное содержимое access-токена
+type Claims struct {
+	UserID    int
+	ID        string // jti, по нему токен отзывается до истечения
+	ExpiresAt time.Time
+	Version   int // версия токенов пользователя на момент выдачи
+}
+
+// tokenClaims — содержимое токена на проводе; ver у токенов без него считается нулевой
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Version int `json:"ver,omitempty"`
 }
 
-func NewTokenManager(secretKey string) *TokenManager {
-	return &TokenManager{[]byte(secretKey)}
+// NewTokenManager — менеджер с одним HMAC-ключом и издателем по умолчанию
+func NewTokenManager(secretKey string, accessTTL time.Duration) *TokenManager {
+	return NewKeyringTokenManager(SingleKeyring(secretKey), TokenConfig{AccessTTL: accessTTL})
 }
 
-func (tm *TokenManager) GenerateToken(userID int) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(), // токен на сутки
-		"iat":     time.Now().Unix(),
+func NewKeyringTokenManager(keys *Keyring, cfg TokenConfig) *TokenManager {
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = DefaultAccessTTL
 	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = DefaultIssuer
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = DefaultIssuer
+	}
+	return &TokenManager{keys: keys, cfg: cfg}
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(tm.secretKey)
+func (tm *TokenManager) AccessTTL() time.Duration {
+	return tm.cfg.AccessTTL
 }
 
-func (tm *TokenManager) ParseToken(tokenStr string) (int, error) {
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		if t.Method != jwt.SigningMethodHS256 {
+// JWKS — открытые ключи для /.well-known/jwks.json
+func (tm *TokenManager) JWKS() JWKSet {
+	return tm.keys.JWKS()
+}
+
+// GenerateToken выдаёт access-токен пользователю с текущей версией его токенов version
+func (tm *TokenManager) GenerateToken(userID, version int) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", fmt.Errorf("generate token id: %w", err)
+	}
+
+	now := time.Now()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			Issuer:    tm.cfg.Issuer,
+			Audience:  jwt.ClaimStrings{tm.cfg.Audience},
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(tm.cfg.AccessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Version: version,
+	}
+
+	kid, key := tm.keys.activeKey()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key.sign)
+}
+
+func (tm *TokenManager) ParseToken(tokenStr string) (Claims, error) {
+	var claims tokenClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (interface{}, error) {
+		// без kid не понять, каким ключом проверять, такие токены не принимаем
+		kid, _ := t.Header["kid"].(string)
+		key, ok := tm.keys.lookup(kid)
+		if !ok {
 			return nil, errs.ErrInvalidToken
 		}
-		return tm.secretKey, nil
-	})
+		// алгоритм задаёт ключ, а не заголовок токена
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, errs.ErrInvalidToken
+		}
+		return key.verify, nil
+	},
+		jwt.WithIssuer(tm.cfg.Issuer),
+		jwt.WithAudience(tm.cfg.Audience),
+		jwt.WithExpirationRequired(),
+	)
 
 	if err != nil || !token.Valid {
-		return 0, errs.ErrInvalidToken
+		return Claims{}, errs.ErrInvalidToken
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Claims{}, errs.ErrInvalidToken
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, errs.ErrInvalidToken
+	// токен без jti нельзя отозвать, такие не принимаем
+	if claims.ID == "" {
+		return Claims{}, errs.ErrInvalidToken
 	}
 
-	idFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return 0, errs.ErrInvalidToken
+	return Claims{UserID: userID, ID: claims.ID, ExpiresAt: claims.ExpiresAt.Time, Version: claims.Version}, nil
+}
+
+// NewRefreshToken возвращает случайный refresh-токен для клиента; на сервере хранится только HashRefreshToken от него
+func NewRefreshToken() (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", fmt.Errorf("generate refresh token: %w", err)
 	}
+	return token, nil
+}
 
-	return int(idFloat), nil
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -9,48 +9,119 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// validClaims — то, что выдал бы GenerateToken для пользователя 1
+func validClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "1",
+		Issuer:    DefaultIssuer,
+		Audience:  jwt.ClaimStrings{DefaultIssuer},
+		ID:        "test",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func sign(t *testing.T, claims jwt.Claims, secret string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = DefaultKeyID
+	tokenStr, err := token.SignedString([]byte(secret))
+	require.NoError(t, err)
+	return tokenStr
+}
+
 func TestGenerateAndParseToken(t *testing.T) {
-	tm := TokenManager{secretKey: []byte("testsecret")}
-	token, err := tm.GenerateToken(42)
+	tm := NewTokenManager("testsecret", time.Hour)
+	token, err := tm.GenerateToken(42, 0)
 	require.NoError(t, err)
 	require.NotEmpty(t, token)
 
-	userID, err := tm.ParseToken(token)
+	claims, err := tm.ParseToken(token)
 	require.NoError(t, err)
-	require.Equal(t, 42, userID)
-}
+	require.Equal(t, 42, claims.UserID)
+	require.NotEmpty(t, claims.ID)
+	require.WithinDuration(t, time.Now().Add(time.Hour), claims.ExpiresAt, time.Minute)
 
-func TestParseInvalidToken(t *testing.T) {
-	tm := TokenManager{secretKey: []byte("testsecret")}
+	// у каждого токена свой jti, иначе отзыв одного задел бы остальные
+	other, err := tm.GenerateToken(42, 0)
+	require.NoError(t, err)
+	otherClaims, err := tm.ParseToken(other)
+	require.NoError(t, err)
+	require.NotEqual(t, claims.ID, otherClaims.ID)
 
-	_, err := tm.ParseToken("invalid.token.string")
-	require.ErrorIs(t, err, errs.ErrInvalidToken)
+	versioned, err := tm.GenerateToken(42, 3)
+	require.NoError(t, err)
+	versionedClaims, err := tm.ParseToken(versioned)
+	require.NoError(t, err)
+	require.Equal(t, 3, versionedClaims.Version)
+	require.Zero(t, claims.Version)
+
+	var registered jwt.RegisteredClaims
+	_, _, err = jwt.NewParser().ParseUnverified(token, &registered)
+	require.NoError(t, err)
+	require.Equal(t, "42", registered.Subject)
+	require.Equal(t, DefaultIssuer, registered.Issuer)
+	require.Equal(t, jwt.ClaimStrings{DefaultIssuer}, registered.Audience)
 }
 
-func TestParseTokenWithWrongSignature(t *testing.T) {
-	tm := TokenManager{secretKey: []byte("testsecret")}
+func TestParseTokenRejectsClaims(t *testing.T) {
+	tm := NewTokenManager("testsecret", time.Hour)
+
+	tests := map[string]func(c *jwt.RegisteredClaims){
+		"no jti":          func(c *jwt.RegisteredClaims) { c.ID = "" },
+		"no subject":      func(c *jwt.RegisteredClaims) { c.Subject = "" },
+		"bad subject":     func(c *jwt.RegisteredClaims) { c.Subject = "alice" },
+		"other issuer":    func(c *jwt.RegisteredClaims) { c.Issuer = "elsewhere" },
+		"other audience":  func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"billing"} },
+		"no expiration":   func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil },
+		"expired":         func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) },
+		"untouched valid": nil,
+	}
+
+	for name, mutate := range tests {
+		claims := validClaims()
+		if mutate == nil {
+			_, err := tm.ParseToken(sign(t, claims, "testsecret"))
+			require.NoError(t, err, name)
+			continue
+		}
+		mutate(&claims)
+		_, err := tm.ParseToken(sign(t, claims, "testsecret"))
+		require.ErrorIs(t, err, errs.ErrInvalidToken, name)
+	}
 
-	claims := jwt.MapClaims{
+	// прежний формат с user_id вместо sub больше не принимается
+	legacy := jwt.MapClaims{
 		"user_id": 1,
+		"jti":     "test",
 		"exp":     time.Now().Add(time.Hour).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	badTokenStr, _ := token.SignedString([]byte("wrongsecret"))
-
-	_, err := tm.ParseToken(badTokenStr)
+	_, err := tm.ParseToken(sign(t, legacy, "testsecret"))
 	require.ErrorIs(t, err, errs.ErrInvalidToken)
 }
 
-func TestParseExpiredToken(t *testing.T) {
-	tm := TokenManager{secretKey: []byte("testsecret")}
+func TestRefreshToken(t *testing.T) {
+	token, err := NewRefreshToken()
+	require.NoError(t, err)
 
-	claims := jwt.MapClaims{
-		"user_id": 1,
-		"exp":     time.Now().Add(-time.Hour).Unix(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	expiredTokenStr, _ := token.SignedString([]byte("testsecret"))
+	other, err := NewRefreshToken()
+	require.NoError(t, err)
+	require.NotEqual(t, token, other)
+
+	require.Equal(t, HashRefreshToken(token), HashRefreshToken(token))
+	require.NotEqual(t, token, HashRefreshToken(token))
+}
+
+func TestParseInvalidToken(t *testing.T) {
+	tm := NewTokenManager("testsecret", time.Hour)
+
+	_, err := tm.ParseToken("invalid.token.string")
+	require.ErrorIs(t, err, errs.ErrInvalidToken)
+}
+
+func TestParseTokenWithWrongSignature(t *testing.T) {
+	tm := NewTokenManager("testsecret", time.Hour)
 
-	_, err := tm.ParseToken(expiredTokenStr)
+	_, err := tm.ParseToken(sign(t, validClaims(), "wrongsecret"))
 	require.ErrorIs(t, err, errs.ErrInvalidToken)
 }
@@ -1,28 +1,89 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/and161185/loyalty/internal/auth"
 )
 
+// DefaultKey — ключ подписи по умолчанию; годится только для локальной разработки
+const DefaultKey = "default-insecure-key"
+
 type Config struct {
-	RunAddress           string
-	DatabaseURI          string
-	AccrualSystemAddress string
-	Key                  string
+	RunAddress             string
+	MetricsAddress         string
+	DatabaseURI            string
+	AccrualSystemAddress   string
+	Key                    string
+	Keys                   string
+	KeysFile               string
+	Dev                    bool
+	InstanceID             string
+	OrderMaxAge            time.Duration
+	AccrualTimeout         time.Duration
+	AccrualMaxIdleConns    int
+	AccrualIdleConnTimeout time.Duration
+	AccrualRateLimit       int
+	BreakerThreshold       int
+	BreakerTimeout         time.Duration
+	AccrualWorkers         int
+	WebhookSecret          string
+	AccessTokenTTL         time.Duration
+	RefreshTokenTTL        time.Duration
+	TokenIssuer            string
+	TokenAudience          string
+	LoginMaxFailures       int
+	LoginIPMaxFailures     int
+	LoginLockout           time.Duration
+	PasswordMinLength      int
+	PasswordMinClasses     int
+	PasswordRejectCommon   bool
 }
 
 func NewConfig() *Config {
 
 	cfg := &Config{}
 	flag.StringVar(&cfg.RunAddress, "a", "localhost:8080", "HTTP server address")
+	flag.StringVar(&cfg.MetricsAddress, "metrics-address", "", "Internal HTTP address for /metrics, empty disables it; must not be reachable from outside")
 	flag.StringVar(&cfg.DatabaseURI, "d", "", "DB connection string")
 	flag.StringVar(&cfg.AccrualSystemAddress, "r", "", "Accural system address")
-	flag.StringVar(&cfg.Key, "k", "default-insecure-key", "Key")
+	flag.StringVar(&cfg.Key, "k", DefaultKey, "Key")
+	flag.StringVar(&cfg.Keys, "keys", "", "Token signing keys as kid:secret or kid:@key.pem separated by commas, the first one signs")
+	flag.StringVar(&cfg.KeysFile, "keys-file", "", "File with token signing keys, one kid:secret or kid:@key.pem per line, the first one signs")
+	flag.BoolVar(&cfg.Dev, "dev", false, "Development mode: allows the default signing key")
+	flag.StringVar(&cfg.InstanceID, "instance-id", "", "Instance ID used to claim orders for accrual polling")
+	flag.DurationVar(&cfg.OrderMaxAge, "order-max-age", 7*24*time.Hour, "Max time to wait for accrual before marking an order INVALID, 0 disables")
+	flag.DurationVar(&cfg.AccrualTimeout, "accrual-timeout", 5*time.Second, "Accrual system request timeout")
+	flag.IntVar(&cfg.AccrualMaxIdleConns, "accrual-max-idle-conns", 16, "Idle connections kept open to the accrual system")
+	flag.DurationVar(&cfg.AccrualIdleConnTimeout, "accrual-idle-conn-timeout", 90*time.Second, "How long an idle connection to the accrual system stays open")
+	flag.IntVar(&cfg.AccrualRateLimit, "accrual-rate-limit", 0, "Accrual system requests per minute, 0 means until it reports a limit")
+	flag.IntVar(&cfg.BreakerThreshold, "accrual-breaker-threshold", 5, "Consecutive accrual failures that open the circuit breaker")
+	flag.DurationVar(&cfg.BreakerTimeout, "accrual-breaker-timeout", 30*time.Second, "How long the accrual circuit breaker stays open before a probe")
+	flag.IntVar(&cfg.AccrualWorkers, "accrual-workers", 5, "Number of accrual polling workers")
+	flag.StringVar(&cfg.WebhookSecret, "webhook-secret", "", "HMAC secret for accrual callbacks, empty disables the callback endpoint")
+	flag.DurationVar(&cfg.AccessTokenTTL, "access-token-ttl", 15*time.Minute, "Access token lifetime")
+	flag.DurationVar(&cfg.RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.StringVar(&cfg.TokenIssuer, "token-issuer", "gophermart", "Issuer (iss) of access tokens")
+	flag.StringVar(&cfg.TokenAudience, "token-audience", "gophermart", "Audience (aud) of access tokens")
+	flag.IntVar(&cfg.LoginMaxFailures, "login-max-failures", 5, "Failed logins for one account before lockout, 0 disables")
+	flag.IntVar(&cfg.LoginIPMaxFailures, "login-ip-max-failures", 50, "Failed logins from one address before lockout, 0 disables")
+	flag.DurationVar(&cfg.LoginLockout, "login-lockout", 15*time.Minute, "Login lockout duration, also the window in which failures are counted")
+	flag.IntVar(&cfg.PasswordMinLength, "password-min-length", 8, "Minimum password length in characters")
+	flag.IntVar(&cfg.PasswordMinClasses, "password-min-classes", 2, "Character classes (lowercase, uppercase, digits, symbols) a password must mix")
+	flag.BoolVar(&cfg.PasswordRejectCommon, "password-reject-common", true, "Reject common passwords and passwords equal to the login")
 	flag.Parse()
 
 	ReadServerEnvironment(cfg)
 
+	if cfg.InstanceID == "" {
+		cfg.InstanceID = defaultInstanceID()
+	}
+
 	return cfg
 }
 
@@ -31,6 +92,10 @@ func ReadServerEnvironment(cfg *Config) {
 		cfg.RunAddress = runAddress
 	}
 
+	if metricsAddress := os.Getenv("METRICS_ADDRESS"); metricsAddress != "" {
+		cfg.MetricsAddress = metricsAddress
+	}
+
 	if databaseURI := os.Getenv("DATABASE_URI"); databaseURI != "" {
 		cfg.DatabaseURI = databaseURI
 	}
@@ -42,4 +107,121 @@ func ReadServerEnvironment(cfg *Config) {
 	if key := os.Getenv("LOYALTY_KEY"); key != "" {
 		cfg.Key = key
 	}
+
+	if keys := os.Getenv("LOYALTY_KEYS"); keys != "" {
+		cfg.Keys = keys
+	}
+
+	if keysFile := os.Getenv("LOYALTY_KEYS_FILE"); keysFile != "" {
+		cfg.KeysFile = keysFile
+	}
+
+	if dev, err := strconv.ParseBool(os.Getenv("LOYALTY_DEV")); err == nil {
+		cfg.Dev = dev
+	}
+
+	if instanceID := os.Getenv("INSTANCE_ID"); instanceID != "" {
+		cfg.InstanceID = instanceID
+	}
+
+	if maxAge, err := time.ParseDuration(os.Getenv("ORDER_MAX_AGE")); err == nil {
+		cfg.OrderMaxAge = maxAge
+	}
+
+	if timeout, err := time.ParseDuration(os.Getenv("ACCRUAL_TIMEOUT")); err == nil {
+		cfg.AccrualTimeout = timeout
+	}
+
+	if maxIdle, err := strconv.Atoi(os.Getenv("ACCRUAL_MAX_IDLE_CONNS")); err == nil {
+		cfg.AccrualMaxIdleConns = maxIdle
+	}
+
+	if timeout, err := time.ParseDuration(os.Getenv("ACCRUAL_IDLE_CONN_TIMEOUT")); err == nil {
+		cfg.AccrualIdleConnTimeout = timeout
+	}
+
+	if rateLimit, err := strconv.Atoi(os.Getenv("ACCRUAL_RATE_LIMIT")); err == nil {
+		cfg.AccrualRateLimit = rateLimit
+	}
+
+	if threshold, err := strconv.Atoi(os.Getenv("ACCRUAL_BREAKER_THRESHOLD")); err == nil {
+		cfg.BreakerThreshold = threshold
+	}
+
+	if timeout, err := time.ParseDuration(os.Getenv("ACCRUAL_BREAKER_TIMEOUT")); err == nil {
+		cfg.BreakerTimeout = timeout
+	}
+
+	if workers, err := strconv.Atoi(os.Getenv("ACCRUAL_WORKERS")); err == nil {
+		cfg.AccrualWorkers = workers
+	}
+
+	if secret := os.Getenv("ACCRUAL_WEBHOOK_SECRET"); secret != "" {
+		cfg.WebhookSecret = secret
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil {
+		cfg.AccessTokenTTL = ttl
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil {
+		cfg.RefreshTokenTTL = ttl
+	}
+
+	if issuer := os.Getenv("TOKEN_ISSUER"); issuer != "" {
+		cfg.TokenIssuer = issuer
+	}
+
+	if audience := os.Getenv("TOKEN_AUDIENCE"); audience != "" {
+		cfg.TokenAudience = audience
+	}
+
+	if failures, err := strconv.Atoi(os.Getenv("LOGIN_MAX_FAILURES")); err == nil {
+		cfg.LoginMaxFailures = failures
+	}
+
+	if failures, err := strconv.Atoi(os.Getenv("LOGIN_IP_MAX_FAILURES")); err == nil {
+		cfg.LoginIPMaxFailures = failures
+	}
+
+	if lockout, err := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT")); err == nil {
+		cfg.LoginLockout = lockout
+	}
+
+	if length, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		cfg.PasswordMinLength = length
+	}
+
+	if classes, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_CLASSES")); err == nil {
+		cfg.PasswordMinClasses = classes
+	}
+
+	if reject, err := strconv.ParseBool(os.Getenv("PASSWORD_REJECT_COMMON")); err == nil {
+		cfg.PasswordRejectCommon = reject
+	}
+}
+
+// Validate проверяет настройки перед запуском сервера
+func (c *Config) Validate() error {
+	// одиночный ключ используется, только если не заданы ни файл, ни список ключей
+	if c.Keys != "" || c.KeysFile != "" || c.Dev {
+		return nil
+	}
+	if c.Key == DefaultKey {
+		return errors.New("refusing to start with the default signing key: set LOYALTY_KEYS_FILE, LOYALTY_KEYS or LOYALTY_KEY, or pass -dev")
+	}
+	// для списка ключей длину проверяет auth.ParseKeyring, для одиночного — здесь
+	if len(c.Key) < auth.MinSecretLen {
+		return fmt.Errorf("signing key is shorter than %d bytes: set a longer LOYALTY_KEY", auth.MinSecretLen)
+	}
+	return nil
+}
+
+// defaultInstanceID отличает реплики друг от друга и от перезапуска той же реплики
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gophermart"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
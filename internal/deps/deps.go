@@ -1,22 +1,77 @@
 package deps
 
 import (
+	"github.com/and161185/loyalty/internal/accrual"
 	"github.com/and161185/loyalty/internal/auth"
+	"github.com/and161185/loyalty/internal/config"
 	"go.uber.org/zap"
 )
 
 type Deps struct {
 	Logger       *zap.SugaredLogger
 	TokenManager *auth.TokenManager
+	Accrual      accrual.AccrualClient
+	Breaker      *accrual.Breaker
 }
 
-func NewDependencies(secretKey string) *Deps {
+func NewDependencies(cfg *config.Config) *Deps {
 	logCfg := zap.NewProductionConfig()
 	logCfg.OutputPaths = []string{"stdout", "server.log"}
 
 	logger := zap.Must(logCfg.Build())
 
-	deps := Deps{Logger: logger.Sugar(), TokenManager: auth.NewTokenManager(secretKey)}
+	sugar := logger.Sugar()
+
+	breaker := accrual.NewBreaker(accrual.BreakerConfig{
+		FailureThreshold: cfg.BreakerThreshold,
+		OpenTimeout:      cfg.BreakerTimeout,
+		OnStateChange: func(from, to accrual.BreakerState) {
+			sugar.Warnf("accrual circuit breaker: %s -> %s", from, to)
+		},
+	})
+
+	// один лимитер на все воркеры: 429 останавливает всех, а не только получившего.
+	// Breaker снаружи, чтобы при разомкнутой цепи не тратить токены лимитера
+	accrualClient := accrual.NewBreakerClient(
+		accrual.NewLimitedClient(
+			accrual.NewHTTPClient(accrual.Config{
+				Address:         cfg.AccrualSystemAddress,
+				Timeout:         cfg.AccrualTimeout,
+				MaxIdleConns:    cfg.AccrualMaxIdleConns,
+				IdleConnTimeout: cfg.AccrualIdleConnTimeout,
+			}),
+			accrual.NewLimiter(cfg.AccrualRateLimit),
+		),
+		breaker,
+	)
+
+	keyring, err := newKeyring(cfg)
+	if err != nil {
+		sugar.Fatalf("load signing keys: %v", err)
+	}
+
+	deps := Deps{
+		Logger: sugar,
+		TokenManager: auth.NewKeyringTokenManager(keyring, auth.TokenConfig{
+			AccessTTL: cfg.AccessTokenTTL,
+			Issuer:    cfg.TokenIssuer,
+			Audience:  cfg.TokenAudience,
+		}),
+		Accrual: accrualClient,
+		Breaker: breaker,
+	}
 
 	return &deps
 }
+
+// newKeyring — ключи подписи токенов: файл важнее списка в переменной окружения, а тот — одиночного ключа
+func newKeyring(cfg *config.Config) (*auth.Keyring, error) {
+	switch {
+	case cfg.KeysFile != "":
+		return auth.LoadKeyringFile(cfg.KeysFile)
+	case cfg.Keys != "":
+		return auth.ParseKeyring(cfg.Keys)
+	default:
+		return auth.SingleKeyring(cfg.Key), nil
+	}
+}
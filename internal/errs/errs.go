@@ -6,3 +6,9 @@ var ErrInsufficientFunds = errors.New("not enough balance")
 var ErrUserNotFound = errors.New("user not found")
 var ErrInvalidToken = errors.New("invalid token")
 var ErrLoginAlreadyExists = errors.New("login already exists")
+var ErrWithdrawalExists = errors.New("withdrawal for this order already exists")
+var ErrWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrIllegalStatusTransition = errors.New("illegal order status transition")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+var ErrWeakPassword = errors.New("password does not meet policy")
@@ -0,0 +1,60 @@
+// Package metrics — счётчики сервиса в формате expvar.
+//
+// Переменные не регистрируются в глобальном expvar: его обработчик публикует ещё и cmdline,
+// а в аргументах запуска лежат пароль БД и ключи подписи. Отдаются они только через Handler.
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+var vars = map[string]expvar.Var{}
+
+func newInt(name string) *expvar.Int {
+	v := new(expvar.Int)
+	vars[name] = v
+	return v
+}
+
+func newString(name string) *expvar.String {
+	v := new(expvar.String)
+	vars[name] = v
+	return v
+}
+
+var (
+	AccrualRequests     = newInt("accrual_requests_total")
+	AccrualFailures     = newInt("accrual_failures_total")
+	AccrualBreakerState = newString("accrual_breaker_state")
+	AccrualBreakerOpens = newInt("accrual_breaker_opens_total")
+	AccrualCallbacks    = newInt("accrual_callbacks_total")
+	AccrualReversals    = newInt("accrual_reversals_total")
+
+	IllegalStatusTransitions = newInt("order_illegal_status_transitions_total")
+
+	LoginLockouts = newInt("login_lockouts_total")
+)
+
+// Handler отдаёт счётчики пакета одним JSON-объектом, как expvar.Handler, но без memstats и cmdline
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, "{\n")
+		for i, name := range names {
+			if i > 0 {
+				fmt.Fprint(w, ",\n")
+			}
+			fmt.Fprintf(w, "%q: %s", name, vars[name].String())
+		}
+		fmt.Fprint(w, "\n}\n")
+	})
+}
@@ -14,11 +14,19 @@ type Storage interface {
 	GetUserByID(ctx context.Context, id int) (model.User, error)
 }
 
+// RevocationStorage — access-токены, отозванные до истечения
+type RevocationStorage interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey   contextKey = "user"
+	ClaimsContextKey contextKey = "claims"
+)
 
-func AuthMiddleware(store Storage, tm *auth.TokenManager) func(http.Handler) http.Handler {
+func AuthMiddleware(store Storage, revoked RevocationStorage, tm *auth.TokenManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -28,13 +36,23 @@ func AuthMiddleware(store Storage, tm *auth.TokenManager) func(http.Handler) htt
 			}
 
 			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-			userID, err := tm.ParseToken(tokenStr)
+			claims, err := tm.ParseToken(tokenStr)
 			if err != nil {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			user, err := store.GetUserByID(r.Context(), userID)
+			isRevoked, err := revoked.IsTokenRevoked(r.Context(), claims.ID)
+			if err != nil {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			if isRevoked {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := store.GetUserByID(r.Context(), claims.UserID)
 			if err != nil {
 				if err == errs.ErrUserNotFound {
 					http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -44,7 +62,14 @@ func AuthMiddleware(store Storage, tm *auth.TokenManager) func(http.Handler) htt
 				return
 			}
 
+			// после смены пароля версия токенов пользователя растёт, и все выданные раньше токены отвергаются
+			if claims.Version != user.TokenVersion {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+			ctx = context.WithValue(ctx, ClaimsContextKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 
 		})
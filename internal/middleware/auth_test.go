@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/and161185/loyalty/internal/auth"
 	"github.com/and161185/loyalty/internal/errs"
@@ -14,21 +15,28 @@ import (
 
 type mockStorage struct {
 	GetUserFunc func(ctx context.Context, id int) (model.User, error)
+	revoked     map[string]bool
 }
 
 func (m *mockStorage) GetUserByID(ctx context.Context, id int) (model.User, error) {
 	return m.GetUserFunc(ctx, id)
 }
 
+func (m *mockStorage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return m.revoked[jti], nil
+}
+
 func TestAuthMiddleware(t *testing.T) {
-	tm := auth.NewTokenManager("test-secret")
+	tm := auth.NewTokenManager("test-secret", time.Hour)
 
-	validToken, _ := tm.GenerateToken(1)
+	validToken, _ := tm.GenerateToken(1, 0)
+	revokedToken, _ := tm.GenerateToken(1, 0)
+	revokedClaims, _ := tm.ParseToken(revokedToken)
 
 	tests := []struct {
 		name           string
 		authHeader     string
-		storage        Storage
+		storage        *mockStorage
 		expectedStatus int
 	}{
 		{
@@ -63,6 +71,24 @@ func TestAuthMiddleware(t *testing.T) {
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
+		{
+			name:       "revoked token",
+			authHeader: "Bearer " + revokedToken,
+			storage: &mockStorage{
+				revoked: map[string]bool{revokedClaims.ID: true},
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "password changed since issue",
+			authHeader: "Bearer " + validToken,
+			storage: &mockStorage{
+				GetUserFunc: func(ctx context.Context, id int) (model.User, error) {
+					return model.User{ID: 1, Login: "test", TokenVersion: 1}, nil
+				},
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:       "ok",
 			authHeader: "Bearer " + validToken,
@@ -83,7 +109,7 @@ func TestAuthMiddleware(t *testing.T) {
 			}
 
 			rr := httptest.NewRecorder()
-			mw := AuthMiddleware(tt.storage, tm)
+			mw := AuthMiddleware(tt.storage, tt.storage, tm)
 			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
 			}))
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/and161185/loyalty/internal/model"
+	"go.uber.org/zap"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyKeyTTL — сколько хранится сохранённый ответ
+const idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyLease — сколько ключ держится за запросом, который ещё обрабатывается. Если процесс упал,
+// не успев сохранить ответ или освободить ключ, после аренды ключ может занять следующий запрос
+const idempotencyLease = time.Minute
+
+// maxIdempotentBodySize — тело запроса читается целиком до обработчика, а номер заказа
+// и запрос на списание укладываются в несколько десятков байт
+const maxIdempotentBodySize = 4 << 10
+
+type IdempotencyStorage interface {
+	ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error)
+	SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error
+	DeleteIdempotencyKey(ctx context.Context, userID int, key string) error
+}
+
+// IdempotencyMiddleware должна стоять после AuthMiddleware: ключи хранятся в разрезе пользователя
+func IdempotencyMiddleware(store IdempotencyStorage, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, ok := r.Context().Value(UserContextKey).(model.User)
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			record := model.IdempotencyRecord{
+				UserID:      user.ID,
+				Key:         key,
+				Fingerprint: requestFingerprint(r, body),
+				ExpiresAt:   time.Now().Add(idempotencyLease),
+			}
+
+			existing, created, err := store.ReserveIdempotencyKey(r.Context(), record)
+			if err != nil {
+				logger.Errorf("reserve idempotency key: %v", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+
+			if !created {
+				switch {
+				case existing.Fingerprint != record.Fingerprint:
+					http.Error(w, "idempotency key reused with different request", http.StatusUnprocessableEntity)
+				case existing.StatusCode == 0:
+					http.Error(w, "request with this idempotency key is in progress", http.StatusConflict)
+				default:
+					if existing.ContentType != "" {
+						w.Header().Set("Content-Type", existing.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(existing.StatusCode)
+					w.Write(existing.Body)
+				}
+				return
+			}
+
+			rec := &recordingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			if rec.statusCode == 0 {
+				rec.statusCode = http.StatusOK
+			}
+
+			// запрос не отработал — ключ освобождаем, чтобы клиент мог повторить
+			if rec.statusCode >= http.StatusInternalServerError {
+				if err := store.DeleteIdempotencyKey(context.WithoutCancel(r.Context()), user.ID, key); err != nil {
+					logger.Errorf("delete idempotency key: %v", err)
+				}
+				return
+			}
+
+			record.StatusCode = rec.statusCode
+			record.ContentType = rec.Header().Get("Content-Type")
+			record.Body = rec.body.Bytes()
+			record.ExpiresAt = time.Now().Add(idempotencyKeyTTL)
+			if err := store.SaveIdempotencyResponse(context.WithoutCancel(r.Context()), record); err != nil {
+				logger.Errorf("save idempotency response: %v", err)
+			}
+		})
+	}
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	if w.statusCode == 0 {
+		w.statusCode = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type memoryIdempotencyStorage struct {
+	mu      sync.Mutex
+	records map[string]model.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStorage() *memoryIdempotencyStorage {
+	return &memoryIdempotencyStorage{records: make(map[string]model.IdempotencyRecord)}
+}
+
+func (m *memoryIdempotencyStorage) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.records[record.Key]; ok && existing.ExpiresAt.After(time.Now()) {
+		return existing, false, nil
+	}
+	m.records[record.Key] = record
+	return record, true, nil
+}
+
+func (m *memoryIdempotencyStorage) SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[record.Key] = record
+	return nil
+}
+
+func (m *memoryIdempotencyStorage) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	store := newMemoryIdempotencyStorage()
+	calls := 0
+	status := http.StatusOK
+
+	handler := IdempotencyMiddleware(store, zaptest.NewLogger(t).Sugar())(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"ok":true}`))
+		}))
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), UserContextKey, model.User{ID: 1}))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("first request", func(t *testing.T) {
+		rr := send("key-1", `{"order":"1","sum":10}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		rr := send("key-1", `{"order":"1","sum":10}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, `{"ok":true}`, rr.Body.String())
+		require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		require.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		rr := send("key-1", `{"order":"1","sum":20}`)
+		require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		store.records["key-2"] = model.IdempotencyRecord{UserID: 1, Key: "key-2", Fingerprint: requestFingerprint(
+			httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", nil), []byte(`{}`)),
+			ExpiresAt: time.Now().Add(idempotencyLease)}
+
+		rr := send("key-2", `{}`)
+		require.Equal(t, http.StatusConflict, rr.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("expired lease", func(t *testing.T) {
+		// запрос, державший ключ, так и не завершился — после аренды ключ переходит к следующему
+		store.records["key-2"] = model.IdempotencyRecord{UserID: 1, Key: "key-2", Fingerprint: requestFingerprint(
+			httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", nil), []byte(`{}`)),
+			ExpiresAt: time.Now().Add(-time.Second)}
+
+		rr := send("key-2", `{}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, 2, calls)
+		require.Greater(t, time.Until(store.records["key-2"].ExpiresAt), idempotencyKeyTTL-time.Minute)
+	})
+
+	t.Run("server error releases key", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		rr := send("key-3", `{}`)
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.NotContains(t, store.records, "key-3")
+
+		status = http.StatusOK
+		rr = send("key-3", `{}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, 4, calls)
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		rr := send("key-4", strings.Repeat("1", maxIdempotentBodySize+1))
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		require.NotContains(t, store.records, "key-4")
+		require.Equal(t, 4, calls)
+	})
+
+	t.Run("no header", func(t *testing.T) {
+		send("", `{}`)
+		send("", `{}`)
+		require.Equal(t, 6, calls)
+	})
+}
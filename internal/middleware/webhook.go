@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+)
+
+const maxWebhookBodySize = 64 << 10
+
+// WebhookSignatureMiddleware пропускает только обратные вызовы, подписанные общим с системой начислений секретом
+func WebhookSignatureMiddleware(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+			if err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+
+			err = accrual.VerifySignature(secret,
+				r.Header.Get(accrual.TimestampHeader), r.Header.Get(accrual.SignatureHeader), body, time.Now())
+			if err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+)
+
+func TestWebhookSignatureMiddleware(t *testing.T) {
+	secret := []byte("webhook-secret")
+	body := `{"order":"12345678903","status":"PROCESSED","accrual":500}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name           string
+		timestamp      string
+		signature      string
+		expectedStatus int
+	}{
+		{
+			name:           "valid",
+			timestamp:      now,
+			signature:      accrual.Sign(secret, now, []byte(body)),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "no signature",
+			timestamp:      now,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong secret",
+			timestamp:      now,
+			signature:      accrual.Sign([]byte("other"), now, []byte(body)),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "stale timestamp",
+			timestamp:      stale,
+			signature:      accrual.Sign(secret, stale, []byte(body)),
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := WebhookSignatureMiddleware(secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got = string(b)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/internal/accrual/callback", strings.NewReader(body))
+			req.Header.Set(accrual.TimestampHeader, tt.timestamp)
+			req.Header.Set(accrual.SignatureHeader, tt.signature)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if tt.expectedStatus == http.StatusOK && got != body {
+				t.Errorf("handler got body %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoAppliedMigrations = errors.New("no applied migrations")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Load читает пары файлов NNNN_name.up.sql / NNNN_name.down.sql и возвращает их по возрастанию версии
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, f := range files {
+		if f.IsDir() || path.Ext(f.Name()) != ".sql" {
+			continue
+		}
+
+		version, name, direction, err := parseFileName(f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", f.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, name)
+		}
+
+		switch direction {
+		case "up":
+			m.Up = string(body)
+		case "down":
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func parseFileName(fileName string) (int64, string, string, error) {
+	base := strings.TrimSuffix(fileName, ".sql")
+
+	dot := strings.LastIndex(base, ".")
+	if dot < 0 {
+		return 0, "", "", fmt.Errorf("migration %s: missing .up/.down suffix", fileName)
+	}
+	direction := base[dot+1:]
+	if direction != "up" && direction != "down" {
+		return 0, "", "", fmt.Errorf("migration %s: unknown direction %q", fileName, direction)
+	}
+	base = base[:dot]
+
+	versionStr, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return 0, "", "", fmt.Errorf("migration %s: expected NNNN_name", fileName)
+	}
+
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("migration %s: invalid version %q", fileName, versionStr)
+	}
+
+	return version, name, direction, nil
+}
+
+// session — соединение с базой, на котором под блокировкой применяются миграции
+type session interface {
+	appliedVersions(ctx context.Context) (map[int64]time.Time, error)
+	apply(ctx context.Context, migration Migration) error
+	revert(ctx context.Context, migration Migration) error
+}
+
+type driver interface {
+	withSession(ctx context.Context, fn func(s session) error) error
+}
+
+type Migrator struct {
+	driver     driver
+	migrations []Migration
+}
+
+func newMigrator(d driver, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{driver: d, migrations: migrations}, nil
+}
+
+// Up применяет все ещё не применённые миграции
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.driver.withSession(ctx, func(s session) error {
+		applied, err := s.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			if err := s.apply(ctx, migration); err != nil {
+				return fmt.Errorf("apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down откатывает последнюю применённую миграцию
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.driver.withSession(ctx, func(s session) error {
+		applied, err := s.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+
+			if err := s.revert(ctx, migration); err != nil {
+				return fmt.Errorf("revert migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			return nil
+		}
+
+		return ErrNoAppliedMigrations
+	})
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var list []Status
+
+	err := m.driver.withSession(ctx, func(s session) error {
+		applied, err := s.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			st := Status{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				st.AppliedAt = &appliedAt
+			}
+			list = append(list, st)
+		}
+
+		return nil
+	})
+
+	return list, err
+}
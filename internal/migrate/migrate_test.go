@@ -0,0 +1,64 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_orders.up.sql":   {Data: []byte("CREATE TABLE orders ();")},
+		"0002_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+		"0001_users.up.sql":    {Data: []byte("CREATE TABLE users ();")},
+		"0001_users.down.sql":  {Data: []byte("DROP TABLE users;")},
+		"README.md":            {Data: []byte("not a migration")},
+	}
+
+	migrations, err := Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+
+	require.Equal(t, int64(1), migrations[0].Version)
+	require.Equal(t, "users", migrations[0].Name)
+	require.Equal(t, "CREATE TABLE users ();", migrations[0].Up)
+	require.Equal(t, "DROP TABLE users;", migrations[0].Down)
+
+	require.Equal(t, int64(2), migrations[1].Version)
+	require.Equal(t, "orders", migrations[1].Name)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "no direction",
+			fsys: fstest.MapFS{"0001_users.sql": {Data: []byte("")}},
+		},
+		{
+			name: "bad version",
+			fsys: fstest.MapFS{"abc_users.up.sql": {Data: []byte("")}},
+		},
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{"0001_users.down.sql": {Data: []byte("DROP TABLE users;")}},
+		},
+		{
+			name: "conflicting names",
+			fsys: fstest.MapFS{
+				"0001_users.up.sql":  {Data: []byte("CREATE TABLE users ();")},
+				"0001_people.up.sql": {Data: []byte("CREATE TABLE people ();")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/accrual/client.go
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	accrual "github.com/and161185/loyalty/internal/accrual"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockAccrualClient is a mock of AccrualClient interface.
+type MockAccrualClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccrualClientMockRecorder
+}
+
+// MockAccrualClientMockRecorder is the mock recorder for MockAccrualClient.
+type MockAccrualClientMockRecorder struct {
+	mock *MockAccrualClient
+}
+
+// NewMockAccrualClient creates a new mock instance.
+func NewMockAccrualClient(ctrl *gomock.Controller) *MockAccrualClient {
+	mock := &MockAccrualClient{ctrl: ctrl}
+	mock.recorder = &MockAccrualClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccrualClient) EXPECT() *MockAccrualClientMockRecorder {
+	return m.recorder
+}
+
+// GetOrder mocks base method.
+func (m *MockAccrualClient) GetOrder(ctx context.Context, number string) (accrual.OrderInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", ctx, number)
+	ret0, _ := ret[0].(accrual.OrderInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockAccrualClientMockRecorder) GetOrder(ctx, number interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockAccrualClient)(nil).GetOrder), ctx, number)
+}
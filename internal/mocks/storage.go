@@ -7,6 +7,7 @@ package mocks
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	model "github.com/and161185/loyalty/internal/model"
 	gomock "github.com/golang/mock/gomock"
@@ -50,6 +51,35 @@ func (mr *MockStorageMockRecorder) AddOrder(ctx, user, order interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockStorage)(nil).AddOrder), ctx, user, order)
 }
 
+// ClaimUnprocessedOrders mocks base method.
+func (m *MockStorage) ClaimUnprocessedOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]model.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimUnprocessedOrders", ctx, owner, limit, lease)
+	ret0, _ := ret[0].([]model.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimUnprocessedOrders indicates an expected call of ClaimUnprocessedOrders.
+func (mr *MockStorageMockRecorder) ClaimUnprocessedOrders(ctx, owner, limit, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimUnprocessedOrders", reflect.TypeOf((*MockStorage)(nil).ClaimUnprocessedOrders), ctx, owner, limit, lease)
+}
+
+// CreateRefreshToken mocks base method.
+func (m *MockStorage) CreateRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", ctx, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockStorageMockRecorder) CreateRefreshToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockStorage)(nil).CreateRefreshToken), ctx, token)
+}
+
 // CreateUser mocks base method.
 func (m *MockStorage) CreateUser(ctx context.Context, login, passwordHash string) error {
 	m.ctrl.T.Helper()
@@ -64,6 +94,49 @@ func (mr *MockStorageMockRecorder) CreateUser(ctx, login, passwordHash interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockStorage)(nil).CreateUser), ctx, login, passwordHash)
 }
 
+// DeleteIdempotencyKey mocks base method.
+func (m *MockStorage) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdempotencyKey", ctx, userID, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotencyKey indicates an expected call of DeleteIdempotencyKey.
+func (mr *MockStorageMockRecorder) DeleteIdempotencyKey(ctx, userID, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotencyKey", reflect.TypeOf((*MockStorage)(nil).DeleteIdempotencyKey), ctx, userID, key)
+}
+
+// DeleteStaleLoginAttempts mocks base method.
+func (m *MockStorage) DeleteStaleLoginAttempts(ctx context.Context, before, now time.Time, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteStaleLoginAttempts", ctx, before, now, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteStaleLoginAttempts indicates an expected call of DeleteStaleLoginAttempts.
+func (mr *MockStorageMockRecorder) DeleteStaleLoginAttempts(ctx, before, now, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteStaleLoginAttempts", reflect.TypeOf((*MockStorage)(nil).DeleteStaleLoginAttempts), ctx, before, now, limit)
+}
+
+// ForgiveLoginAttempt mocks base method.
+func (m *MockStorage) ForgiveLoginAttempt(ctx context.Context, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForgiveLoginAttempt", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForgiveLoginAttempt indicates an expected call of ForgiveLoginAttempt.
+func (mr *MockStorageMockRecorder) ForgiveLoginAttempt(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForgiveLoginAttempt", reflect.TypeOf((*MockStorage)(nil).ForgiveLoginAttempt), ctx, key)
+}
+
 // GetUnprocessedOrders mocks base method.
 func (m *MockStorage) GetUnprocessedOrders(ctx context.Context) ([]model.Order, error) {
 	m.ctrl.T.Helper()
@@ -94,7 +167,7 @@ func (mr *MockStorageMockRecorder) GetUserBalance(ctx, user interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserBalance", reflect.TypeOf((*MockStorage)(nil).GetUserBalance), ctx, user)
 }
 
-// GetUserById mocks base method.
+// GetUserByID mocks base method.
 func (m *MockStorage) GetUserByID(ctx context.Context, id int) (model.User, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetUserByID", ctx, id)
@@ -103,7 +176,7 @@ func (m *MockStorage) GetUserByID(ctx context.Context, id int) (model.User, erro
 	return ret0, ret1
 }
 
-// GetUserById indicates an expected call of GetUserById.
+// GetUserByID indicates an expected call of GetUserByID.
 func (mr *MockStorageMockRecorder) GetUserByID(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByID", reflect.TypeOf((*MockStorage)(nil).GetUserByID), ctx, id)
@@ -125,6 +198,21 @@ func (mr *MockStorageMockRecorder) GetUserByLogin(ctx, login interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByLogin", reflect.TypeOf((*MockStorage)(nil).GetUserByLogin), ctx, login)
 }
 
+// GetUserOrder mocks base method.
+func (m *MockStorage) GetUserOrder(ctx context.Context, user model.User, number string) (model.OrderDetails, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserOrder", ctx, user, number)
+	ret0, _ := ret[0].(model.OrderDetails)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserOrder indicates an expected call of GetUserOrder.
+func (mr *MockStorageMockRecorder) GetUserOrder(ctx, user, number interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserOrder", reflect.TypeOf((*MockStorage)(nil).GetUserOrder), ctx, user, number)
+}
+
 // GetUserOrders mocks base method.
 func (m *MockStorage) GetUserOrders(ctx context.Context, user model.User) ([]model.Order, error) {
 	m.ctrl.T.Helper()
@@ -140,6 +228,21 @@ func (mr *MockStorageMockRecorder) GetUserOrders(ctx, user interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserOrders", reflect.TypeOf((*MockStorage)(nil).GetUserOrders), ctx, user)
 }
 
+// GetWithdrawal mocks base method.
+func (m *MockStorage) GetWithdrawal(ctx context.Context, user model.User, order string) (model.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithdrawal", ctx, user, order)
+	ret0, _ := ret[0].(model.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithdrawal indicates an expected call of GetWithdrawal.
+func (mr *MockStorageMockRecorder) GetWithdrawal(ctx, user, order interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawal", reflect.TypeOf((*MockStorage)(nil).GetWithdrawal), ctx, user, order)
+}
+
 // GetWithdrawals mocks base method.
 func (m *MockStorage) GetWithdrawals(ctx context.Context, user model.User) ([]model.Withdrawal, error) {
 	m.ctrl.T.Helper()
@@ -155,6 +258,180 @@ func (mr *MockStorageMockRecorder) GetWithdrawals(ctx, user interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithdrawals", reflect.TypeOf((*MockStorage)(nil).GetWithdrawals), ctx, user)
 }
 
+// IsTokenRevoked mocks base method.
+func (m *MockStorage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsTokenRevoked", ctx, jti)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTokenRevoked indicates an expected call of IsTokenRevoked.
+func (mr *MockStorageMockRecorder) IsTokenRevoked(ctx, jti interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTokenRevoked", reflect.TypeOf((*MockStorage)(nil).IsTokenRevoked), ctx, jti)
+}
+
+// RecordLoginAttempt mocks base method.
+func (m *MockStorage) RecordLoginAttempt(ctx context.Context, key string, limit model.LoginLimit, now time.Time) (model.LoginAttempts, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordLoginAttempt", ctx, key, limit, now)
+	ret0, _ := ret[0].(model.LoginAttempts)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// RecordLoginAttempt indicates an expected call of RecordLoginAttempt.
+func (mr *MockStorageMockRecorder) RecordLoginAttempt(ctx, key, limit, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordLoginAttempt", reflect.TypeOf((*MockStorage)(nil).RecordLoginAttempt), ctx, key, limit, now)
+}
+
+// ReleaseOrder mocks base method.
+func (m *MockStorage) ReleaseOrder(ctx context.Context, owner, number string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseOrder", ctx, owner, number)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseOrder indicates an expected call of ReleaseOrder.
+func (mr *MockStorageMockRecorder) ReleaseOrder(ctx, owner, number interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseOrder", reflect.TypeOf((*MockStorage)(nil).ReleaseOrder), ctx, owner, number)
+}
+
+// ReserveIdempotencyKey mocks base method.
+func (m *MockStorage) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveIdempotencyKey", ctx, record)
+	ret0, _ := ret[0].(model.IdempotencyRecord)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ReserveIdempotencyKey indicates an expected call of ReserveIdempotencyKey.
+func (mr *MockStorageMockRecorder) ReserveIdempotencyKey(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveIdempotencyKey", reflect.TypeOf((*MockStorage)(nil).ReserveIdempotencyKey), ctx, record)
+}
+
+// ResetLoginAttempts mocks base method.
+func (m *MockStorage) ResetLoginAttempts(ctx context.Context, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginAttempts", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginAttempts indicates an expected call of ResetLoginAttempts.
+func (mr *MockStorageMockRecorder) ResetLoginAttempts(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginAttempts", reflect.TypeOf((*MockStorage)(nil).ResetLoginAttempts), ctx, key)
+}
+
+// ReverseOrder mocks base method.
+func (m *MockStorage) ReverseOrder(ctx context.Context, number, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReverseOrder", ctx, number, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReverseOrder indicates an expected call of ReverseOrder.
+func (mr *MockStorageMockRecorder) ReverseOrder(ctx, number, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReverseOrder", reflect.TypeOf((*MockStorage)(nil).ReverseOrder), ctx, number, reason)
+}
+
+// RevokeRefreshToken mocks base method.
+func (m *MockStorage) RevokeRefreshToken(ctx context.Context, userID int, hash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeRefreshToken", ctx, userID, hash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeRefreshToken indicates an expected call of RevokeRefreshToken.
+func (mr *MockStorageMockRecorder) RevokeRefreshToken(ctx, userID, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeRefreshToken", reflect.TypeOf((*MockStorage)(nil).RevokeRefreshToken), ctx, userID, hash)
+}
+
+// RevokeToken mocks base method.
+func (m *MockStorage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeToken", ctx, jti, expiresAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeToken indicates an expected call of RevokeToken.
+func (mr *MockStorageMockRecorder) RevokeToken(ctx, jti, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeToken", reflect.TypeOf((*MockStorage)(nil).RevokeToken), ctx, jti, expiresAt)
+}
+
+// RevokeUserSessions mocks base method.
+func (m *MockStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserSessions", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserSessions indicates an expected call of RevokeUserSessions.
+func (mr *MockStorageMockRecorder) RevokeUserSessions(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserSessions", reflect.TypeOf((*MockStorage)(nil).RevokeUserSessions), ctx, userID)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockStorage) RotateRefreshToken(ctx context.Context, hash string, next model.RefreshToken) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", ctx, hash, next)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockStorageMockRecorder) RotateRefreshToken(ctx, hash, next interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockStorage)(nil).RotateRefreshToken), ctx, hash, next)
+}
+
+// SaveIdempotencyResponse mocks base method.
+func (m *MockStorage) SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveIdempotencyResponse", ctx, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveIdempotencyResponse indicates an expected call of SaveIdempotencyResponse.
+func (mr *MockStorageMockRecorder) SaveIdempotencyResponse(ctx, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveIdempotencyResponse", reflect.TypeOf((*MockStorage)(nil).SaveIdempotencyResponse), ctx, record)
+}
+
+// ScheduleNextCheck mocks base method.
+func (m *MockStorage) ScheduleNextCheck(ctx context.Context, number string, nextCheckAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ScheduleNextCheck", ctx, number, nextCheckAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ScheduleNextCheck indicates an expected call of ScheduleNextCheck.
+func (mr *MockStorageMockRecorder) ScheduleNextCheck(ctx, number, nextCheckAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ScheduleNextCheck", reflect.TypeOf((*MockStorage)(nil).ScheduleNextCheck), ctx, number, nextCheckAt)
+}
+
 // UpdateOrder mocks base method.
 func (m *MockStorage) UpdateOrder(ctx context.Context, order model.Order) error {
 	m.ctrl.T.Helper()
@@ -169,8 +446,22 @@ func (mr *MockStorageMockRecorder) UpdateOrder(ctx, order interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorage)(nil).UpdateOrder), ctx, order)
 }
 
+// UpdatePassword mocks base method.
+func (m *MockStorage) UpdatePassword(ctx context.Context, userID int, passwordHash string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePassword", ctx, userID, passwordHash)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePassword indicates an expected call of UpdatePassword.
+func (mr *MockStorageMockRecorder) UpdatePassword(ctx, userID, passwordHash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePassword", reflect.TypeOf((*MockStorage)(nil).UpdatePassword), ctx, userID, passwordHash)
+}
+
 // WithdrawBalance mocks base method.
-func (m *MockStorage) WithdrawBalance(ctx context.Context, user model.User, order string, sum float64) error {
+func (m *MockStorage) WithdrawBalance(ctx context.Context, user model.User, order string, sum model.Points) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "WithdrawBalance", ctx, user, order, sum)
 	ret0, _ := ret[0].(error)
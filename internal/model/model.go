@@ -10,27 +10,136 @@ const (
 	Invalid    OrderStatus = "INVALID"
 	Processing OrderStatus = "PROCESSING"
 	Processed  OrderStatus = "PROCESSED"
+	Reversed   OrderStatus = "REVERSED"
 )
 
 type Balance struct {
-	Current   float64
-	Withdrawn float64
+	Current   Points `json:"current"`
+	Withdrawn Points `json:"withdrawn"`
 }
 
 type Order struct {
 	Number     string      `json:"number"`
 	Status     OrderStatus `json:"status"`
-	Accrual    *float64    `json:"accrual,omitempty"`
+	Accrual    *Points     `json:"accrual,omitempty"`
 	UploadedAt time.Time   `json:"uploaded_at"`
+
+	// служебные поля опроса системы начислений, наружу не отдаются
+	Attempts      int    `json:"-"`
+	InvalidReason string `json:"-"`
+}
+
+type OrderStatusChange struct {
+	Status    OrderStatus `json:"status"`
+	Accrual   *Points     `json:"accrual,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+	ChangedAt time.Time   `json:"changed_at"`
+}
+
+// OrderDetails — заказ вместе с историей смены статусов, от загрузки к последнему
+type OrderDetails struct {
+	Order
+	History []OrderStatusChange `json:"history"`
 }
 
 type Withdrawal struct {
-	Order       string
-	Sum         float64
-	ProcessedAt time.Time
+	Order       string    `json:"order"`
+	Sum         Points    `json:"sum"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+type LedgerEntryType string
+
+const (
+	LedgerAccrual    LedgerEntryType = "ACCRUAL"
+	LedgerWithdrawal LedgerEntryType = "WITHDRAWAL"
+	LedgerAdjustment LedgerEntryType = "ADJUSTMENT"
+	LedgerReversal   LedgerEntryType = "REVERSAL"
+	// LedgerRefund — возврат ошибочного списания: уменьшает и баланс, и сумму списанного
+	LedgerRefund LedgerEntryType = "REFUND"
+)
+
+// CountsAsWithdrawn — входит ли проводка в сумму списанного
+func (t LedgerEntryType) CountsAsWithdrawn() bool {
+	return t == LedgerWithdrawal || t == LedgerRefund
+}
+
+// LedgerEntry — движение баллов по счёту пользователя, Amount со знаком
+type LedgerEntry struct {
+	ID            int64
+	TransactionID int64
+	UserID        int
+	Type          LedgerEntryType
+	Amount        Points
+	OrderNumber   string
+	Comment       string
+	CreatedAt     time.Time
+}
+
+// RefreshToken — серверная запись refresh-токена; сам токен не хранится, только его хеш.
+// Токены, полученные ротацией от одного входа, образуют семейство FamilyID
+type RefreshToken struct {
+	Hash      string
+	UserID    int
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+// TokenPair — ответ на вход и обновление токенов
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // время жизни access-токена в секундах
+}
+
+// LoginAttempts — неудачные входы подряд по одному ключу: логину или адресу клиента.
+// Попытка засчитывается до проверки пароля, поэтому идущая сейчас проверка тоже в счёте
+type LoginAttempts struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time // нулевое, если вход не заблокирован
+}
+
+// LoginLimit — когда блокировать вход по ключу
+type LoginLimit struct {
+	MaxFailures int           // после стольких неудач ключ блокируется на Lockout
+	Lockout     time.Duration // заодно окно: неудачи старше него забываются
+	Delay       time.Duration // пауза после второй неудачи, удваивается с каждой следующей; 0 — без пауз
+}
+
+// LockFor — на сколько заблокировать ключ после failures неудач подряд; 0 — не блокировать
+func (l LoginLimit) LockFor(failures int) time.Duration {
+	if failures >= l.MaxFailures {
+		return l.Lockout
+	}
+	if l.Delay <= 0 || failures < 2 {
+		return 0
+	}
+
+	shift := failures - 2
+	if shift >= 30 {
+		return l.Lockout
+	}
+	if delay := l.Delay << shift; delay < l.Lockout {
+		return delay
+	}
+	return l.Lockout
 }
 
 type User struct {
 	ID    int
 	Login string
+	// TokenVersion растёт при смене пароля; access-токены с другой версией недействительны
+	TokenVersion int
+}
+
+// IdempotencyRecord — сохранённый ответ на запрос с заголовком Idempotency-Key
+type IdempotencyRecord struct {
+	UserID      int
+	Key         string
+	Fingerprint string
+	StatusCode  int // 0, пока исходный запрос ещё обрабатывается
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
 }
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Points — количество баллов в сотых долях, чтобы суммы считались без ошибок округления
+type Points int64
+
+// Point — один балл
+const Point Points = 100
+
+// ParsePoints разбирает десятичную запись, в том числе с экспонентой.
+// Всё, что мельче сотой доли, округляется до ближайшей сотой (половина — от нуля).
+func ParsePoints(s string) (Points, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("parse points: empty value")
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("parse points: invalid number %q", s)
+	}
+
+	r.Mul(r, big.NewRat(int64(Point), 1))
+
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("parse points: %q is out of range", s)
+	}
+
+	p := quo.Int64()
+	if r.Sign() < 0 {
+		p = -p
+	}
+
+	return Points(p), nil
+}
+
+func (p Points) String() string {
+	sign := ""
+	abs := uint64(p)
+	if p < 0 {
+		sign = "-"
+		abs = uint64(-p)
+		if p == math.MinInt64 {
+			abs = uint64(math.MaxInt64) + 1
+		}
+	}
+
+	whole := abs / uint64(Point)
+	frac := abs % uint64(Point)
+
+	switch {
+	case frac == 0:
+		return sign + strconv.FormatUint(whole, 10)
+	case frac%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, whole, frac/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, whole, frac)
+	}
+}
+
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Points) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	parsed, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+
+	*p = parsed
+	return nil
+}
+
+// Scan читает NUMERIC из базы
+func (p *Points) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*p = 0
+		return nil
+	case int64:
+		*p = Points(v) * Point
+		return nil
+	case float64:
+		return p.scanString(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		return p.scanString(v)
+	case []byte:
+		return p.scanString(string(v))
+	default:
+		return fmt.Errorf("scan points: unsupported type %T", src)
+	}
+}
+
+func (p *Points) scanString(s string) error {
+	parsed, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+
+	*p = parsed
+	return nil
+}
+
+// Value пишет баллы в базу десятичной строкой
+func (p Points) Value() (driver.Value, error) {
+	return p.String(), nil
+}
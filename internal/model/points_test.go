@@ -0,0 +1,88 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Points
+	}{
+		{"0", 0},
+		{"42", 4200},
+		{"500.5", 50050},
+		{"0.01", 1},
+		{"-12.34", -1234},
+		{"1e3", 100000},
+		{"0.1", 10},
+		{"0.2", 20},
+		{"0.125", 13},
+		{"-0.125", -13},
+		{"0.124", 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p, err := ParsePoints(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, p)
+		})
+	}
+}
+
+func TestParsePointsInvalid(t *testing.T) {
+	for _, input := range []string{"", "abc", "1,5", "1e30"} {
+		_, err := ParsePoints(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestPointsString(t *testing.T) {
+	require.Equal(t, "0", Points(0).String())
+	require.Equal(t, "42", Points(4200).String())
+	require.Equal(t, "500.5", Points(50050).String())
+	require.Equal(t, "0.05", Points(5).String())
+	require.Equal(t, "-12.34", Points(-1234).String())
+}
+
+func TestPointsJSON(t *testing.T) {
+	// 0.1 + 0.2 во float64 даёт 0.30000000000000004
+	var req WithdrawRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"1","sum":0.1}`), &req))
+	sum := req.Sum
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"1","sum":0.2}`), &req))
+	sum += req.Sum
+
+	balance := Balance{Current: sum, Withdrawn: 42 * Point}
+	data, err := json.Marshal(balance)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"current":0.3,"withdrawn":42}`, string(data))
+
+	require.Error(t, json.Unmarshal([]byte(`{"sum":"10"}`), &req))
+}
+
+func TestPointsScan(t *testing.T) {
+	var p Points
+	require.NoError(t, p.Scan("729.98"))
+	require.Equal(t, Points(72998), p)
+
+	require.NoError(t, p.Scan([]byte("1.5")))
+	require.Equal(t, Points(150), p)
+
+	require.NoError(t, p.Scan(int64(3)))
+	require.Equal(t, 3*Point, p)
+
+	require.NoError(t, p.Scan(0.3))
+	require.Equal(t, Points(30), p)
+
+	require.NoError(t, p.Scan(nil))
+	require.Equal(t, Points(0), p)
+
+	v, err := Points(-1250).Value()
+	require.NoError(t, err)
+	require.Equal(t, "-12.5", v)
+}
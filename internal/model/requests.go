@@ -6,6 +6,6 @@ type Credentials struct {
 }
 
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string `json:"order"`
+	Sum   Points `json:"sum"`
 }
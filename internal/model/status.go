@@ -0,0 +1,37 @@
+package model
+
+// orderTransitions — из каких статусов заказ может перейти в данный.
+// PROCESSED и INVALID окончательные для системы начислений; REVERSED здесь нет намеренно:
+// в него заказ переводит только отмена начисления вместе со списанием баллов
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	Processing: {New},
+	Processed:  {New, Processing},
+	Invalid:    {New, Processing},
+}
+
+// PreviousStatuses — статусы, из которых допустим переход в next; пусто, если в next перейти нельзя
+func PreviousStatuses(next OrderStatus) []OrderStatus {
+	return orderTransitions[next]
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, prev := range orderTransitions[next] {
+		if prev == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s OrderStatus) IsFinal() bool {
+	return s == Processed || s == Invalid || s == Reversed
+}
+
+// FromAccrualStatus переводит статус системы начислений в статус заказа:
+// REGISTERED для нас означает, что расчёт ещё идёт
+func FromAccrualStatus(s OrderStatus) OrderStatus {
+	if s == Registered {
+		return Processing
+	}
+	return s
+}
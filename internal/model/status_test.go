@@ -0,0 +1,41 @@
+package model
+
+import "testing"
+
+func TestOrderStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		want     bool
+	}{
+		{New, Processing, true},
+		{New, Processed, true},
+		{New, Invalid, true},
+		{Processing, Processed, true},
+		{Processing, Invalid, true},
+		{Processing, New, false},
+		{Processed, Processing, false},
+		{Processed, Invalid, false},
+		{Invalid, Processed, false},
+		{New, Registered, false},
+		// отмена начисления идёт мимо обычных переходов
+		{Processed, Reversed, false},
+		{Reversed, Processed, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s: got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestFromAccrualStatus(t *testing.T) {
+	if got := FromAccrualStatus(Registered); got != Processing {
+		t.Errorf("REGISTERED mapped to %s", got)
+	}
+	for _, s := range []OrderStatus{Processing, Processed, Invalid} {
+		if got := FromAccrualStatus(s); got != s {
+			t.Errorf("%s mapped to %s", s, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/metrics"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/utils"
+)
+
+// AccrualCallbackHandler принимает от системы начислений статус заказа в том же виде, что и GET /api/orders/{number}.
+// Повторная доставка безопасна: повтор того же статуса ничего не меняет, а откат окончательного статуса отклоняется с 409.
+// На неизвестный заказ отвечает 404
+func (s *Server) AccrualCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	var info accrual.OrderInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !utils.IsValidLuhn(info.Order) || !isAccrualStatus(info.Status) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if info.Status != model.Processed {
+		info.Accrual = nil
+	}
+
+	order := model.Order{Number: info.Order, Status: model.FromAccrualStatus(info.Status), Accrual: info.Accrual}
+	if err := s.applyStatus(r.Context(), order); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrOrderNotFound):
+			http.Error(w, "order not found", http.StatusNotFound)
+		case errors.Is(err, errs.ErrIllegalStatusTransition):
+			http.Error(w, "illegal status transition", http.StatusConflict)
+		default:
+			s.deps.Logger.Errorf("accrual callback: update order: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	metrics.AccrualCallbacks.Add(1)
+	w.WriteHeader(http.StatusOK)
+}
+
+// AccrualReversalHandler отменяет начисление по обработанному заказу. Баллы списываются, даже если
+// пользователь их уже потратил, — тогда баланс уходит в минус. Повтор отмены отвечает 200,
+// отмена необработанного заказа — 409
+func (s *Server) AccrualReversalHandler(w http.ResponseWriter, r *http.Request) {
+	var reversal accrual.Reversal
+	if err := json.NewDecoder(r.Body).Decode(&reversal); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !utils.IsValidLuhn(reversal.Order) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.orderStorage.ReverseOrder(r.Context(), reversal.Order, reversal.Reason); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrOrderNotFound):
+			http.Error(w, "order not found", http.StatusNotFound)
+		case errors.Is(err, errs.ErrIllegalStatusTransition):
+			metrics.IllegalStatusTransitions.Add(1)
+			http.Error(w, "order is not processed", http.StatusConflict)
+		default:
+			s.deps.Logger.Errorf("accrual reversal: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	metrics.AccrualReversals.Add(1)
+	s.deps.Logger.Infof("order %s: accrual reversed: %s", reversal.Order, reversal.Reason)
+	w.WriteHeader(http.StatusOK)
+}
+
+func isAccrualStatus(status model.OrderStatus) bool {
+	switch status {
+	case model.Registered, model.Processing, model.Processed, model.Invalid:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/metrics"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/golang/mock/gomock"
+)
+
+func signedCallback(secret, body string) *http.Request {
+	return signedWebhook("/api/internal/accrual/callback", secret, body)
+}
+
+func signedWebhook(path, secret, body string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(accrual.TimestampHeader, ts)
+	req.Header.Set(accrual.SignatureHeader, accrual.Sign([]byte(secret), ts, []byte(body)))
+	return req
+}
+
+func TestAccrualCallback(t *testing.T) {
+	srv, mock := setup(t)
+	srv.config.WebhookSecret = "webhook"
+	router := srv.buildRouter()
+
+	mock.EXPECT().
+		UpdateOrder(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, o model.Order) error {
+			if o.Number != "12345678903" || o.Status != model.Processed || o.Accrual == nil || *o.Accrual != 500*model.Point {
+				t.Errorf("unexpected update %+v", o)
+			}
+			return nil
+		}).
+		Times(2)
+
+	// повторная доставка того же события проходит тем же путём, UpdateOrder её не задваивает
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, signedCallback("webhook", `{"order":"12345678903","status":"PROCESSED","accrual":500}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+	}
+}
+
+func TestAccrualCallback_Rejects(t *testing.T) {
+	srv, _ := setup(t)
+	srv.config.WebhookSecret = "webhook"
+	router := srv.buildRouter()
+
+	tests := []struct {
+		name           string
+		req            *http.Request
+		expectedStatus int
+	}{
+		{
+			name:           "wrong secret",
+			req:            signedCallback("other", `{"order":"12345678903","status":"PROCESSED"}`),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "bad order number",
+			req:            signedCallback("webhook", `{"order":"12345678900","status":"PROCESSED"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown status",
+			req:            signedCallback("webhook", `{"order":"12345678903","status":"DONE"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.req)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAccrualCallback_IllegalTransition(t *testing.T) {
+	srv, mock := setup(t)
+	srv.config.WebhookSecret = "webhook"
+
+	mock.EXPECT().
+		UpdateOrder(gomock.Any(), gomock.Any()).
+		Return(fmt.Errorf("%w: PROCESSED -> PROCESSING", errs.ErrIllegalStatusTransition))
+
+	before := metrics.IllegalStatusTransitions.Value()
+
+	w := httptest.NewRecorder()
+	srv.buildRouter().ServeHTTP(w, signedCallback("webhook", `{"order":"12345678903","status":"REGISTERED"}`))
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", w.Code)
+	}
+	if metrics.IllegalStatusTransitions.Value() != before+1 {
+		t.Error("expected illegal transition to be counted")
+	}
+}
+
+func TestAccrualCallback_UnknownOrder(t *testing.T) {
+	srv, mock := setup(t)
+	srv.config.WebhookSecret = "webhook"
+
+	mock.EXPECT().
+		UpdateOrder(gomock.Any(), gomock.Any()).
+		Return(errs.ErrOrderNotFound)
+
+	before := metrics.AccrualCallbacks.Value()
+
+	w := httptest.NewRecorder()
+	srv.buildRouter().ServeHTTP(w, signedCallback("webhook", `{"order":"12345678903","status":"PROCESSED","accrual":500}`))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+	if metrics.AccrualCallbacks.Value() != before {
+		t.Error("callback for an unknown order must not be counted")
+	}
+}
+
+func TestAccrualCallback_DisabledWithoutSecret(t *testing.T) {
+	srv, _ := setup(t)
+
+	w := httptest.NewRecorder()
+	srv.buildRouter().ServeHTTP(w, signedCallback("", `{"order":"12345678903","status":"PROCESSED"}`))
+	if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected callback route to be absent, got %d", w.Code)
+	}
+}
+
+func TestAccrualReversal(t *testing.T) {
+	srv, mock := setup(t)
+	srv.config.WebhookSecret = "webhook"
+	router := srv.buildRouter()
+
+	gomock.InOrder(
+		mock.EXPECT().ReverseOrder(gomock.Any(), "12345678903", "purchase cancelled").Return(nil),
+		mock.EXPECT().ReverseOrder(gomock.Any(), "79927398713", "").Return(errs.ErrOrderNotFound),
+		mock.EXPECT().ReverseOrder(gomock.Any(), "2377225624", "").
+			Return(fmt.Errorf("%w: PROCESSING -> REVERSED", errs.ErrIllegalStatusTransition)),
+	)
+
+	tests := []struct {
+		body           string
+		secret         string
+		expectedStatus int
+	}{
+		{`{"order":"12345678903","reason":"purchase cancelled"}`, "webhook", http.StatusOK},
+		{`{"order":"79927398713"}`, "webhook", http.StatusNotFound},
+		{`{"order":"2377225624"}`, "webhook", http.StatusConflict},
+		{`{"order":"12345678900"}`, "webhook", http.StatusBadRequest},
+		{`{"order":"12345678903"}`, "other", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, signedWebhook("/api/internal/accrual/reversal", tt.secret, tt.body))
+		if w.Code != tt.expectedStatus {
+			t.Errorf("%s: expected %d, got %d", tt.body, tt.expectedStatus, w.Code)
+		}
+	}
+}
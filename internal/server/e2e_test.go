@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/accrualsim"
+	"github.com/and161185/loyalty/internal/auth"
+	"github.com/and161185/loyalty/internal/config"
+	"github.com/and161185/loyalty/internal/deps"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func post(t *testing.T, url, token, contentType, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+// TestEndToEndAccrual прогоняет заказ через gophermart и имитатор системы начислений:
+// загрузка, опрос воркерами, начисление на баланс
+func TestEndToEndAccrual(t *testing.T) {
+	sim := httptest.NewServer(accrualsim.New(accrualsim.Config{Deterministic: true}).Handler())
+	defer sim.Close()
+
+	require.Equal(t, http.StatusOK, post(t, sim.URL+"/api/goods", "", "application/json",
+		`{"match":"Bork","reward":10,"reward_type":"%"}`).StatusCode)
+	require.Equal(t, http.StatusAccepted, post(t, sim.URL+"/api/orders", "", "application/json",
+		`{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000}]}`).StatusCode)
+
+	cfg := &config.Config{AccrualWorkers: 2, InstanceID: "e2e"}
+	d := &deps.Deps{
+		Logger:       zaptest.NewLogger(t).Sugar(),
+		TokenManager: auth.NewTokenManager("e2e-secret", time.Minute),
+		Accrual:      accrual.NewHTTPClient(accrual.Config{Address: sim.URL, Timeout: time.Second}),
+	}
+	store := storage.NewMemoryStorage()
+	srv := NewServer(store, store, store, store, store, store, cfg, d)
+
+	ts := httptest.NewServer(srv.buildRouter())
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.OrdersStatusControl(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	resp := post(t, ts.URL+"/api/user/register", "", "application/json", `{"login":"alice","password":"secret"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	token := resp.Header.Get("Authorization")
+
+	require.Equal(t, http.StatusAccepted, post(t, ts.URL+"/api/user/orders", token, "text/plain", "12345678903").StatusCode)
+	// о втором заказе система начислений не знает — он остаётся NEW
+	require.Equal(t, http.StatusAccepted, post(t, ts.URL+"/api/user/orders", token, "text/plain", "2377225624").StatusCode)
+
+	getOrders := func() map[string]model.Order {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/orders", nil)
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var list []model.Order
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+
+		orders := make(map[string]model.Order)
+		for _, o := range list {
+			orders[o.Number] = o
+		}
+		return orders
+	}
+
+	require.Eventually(t, func() bool {
+		return getOrders()["12345678903"].Status == model.Processed
+	}, 5*time.Second, 50*time.Millisecond)
+
+	orders := getOrders()
+	require.Equal(t, 700*model.Point, *orders["12345678903"].Accrual)
+	require.Equal(t, model.New, orders["2377225624"].Status)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/user/balance", nil)
+	req.Header.Set("Authorization", token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var balance model.Balance
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&balance))
+	require.Equal(t, model.Balance{Current: 700 * model.Point}, balance)
+}
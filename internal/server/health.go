@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/and161185/loyalty/internal/accrual"
+)
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	Accrual string `json:"accrual"`
+}
+
+// HealthHandler всегда отвечает 200: недоступность системы начислений не повод снимать экземпляр
+// с балансировки, поэтому она отражается только статусом degraded
+func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: "ok", Accrual: "unknown"}
+
+	if s.deps.Breaker != nil {
+		state := s.deps.Breaker.State()
+		resp.Accrual = state.String()
+		if state != accrual.StateClosed {
+			resp.Status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/accrual"
+)
+
+func TestHealthHandler(t *testing.T) {
+	srv, _ := setup(t)
+	breaker := accrual.NewBreaker(accrual.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+	srv.deps.Breaker = breaker
+
+	check := func(wantStatus, wantAccrual string) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		srv.HealthHandler(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+
+		var resp healthResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Status != wantStatus || resp.Accrual != wantAccrual {
+			t.Errorf("expected %s/%s, got %+v", wantStatus, wantAccrual, resp)
+		}
+	}
+
+	check("ok", "closed")
+
+	breaker.Allow()
+	breaker.Record(errors.New("connection refused"))
+
+	check("degraded", "open")
+	if !srv.accrualUnavailable() {
+		t.Error("expected polling to pause while breaker is open")
+	}
+}
+
+func TestMetricsNotPublic(t *testing.T) {
+	srv, _ := setup(t)
+
+	w := httptest.NewRecorder()
+	srv.buildRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected /metrics to be absent from the public router, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.buildMetricsRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(w.Body).Decode(&vars); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// аргументы запуска содержат секреты и не должны попадать в метрики
+	for _, name := range []string{"cmdline", "memstats"} {
+		if _, ok := vars[name]; ok {
+			t.Errorf("unexpected %s in metrics", name)
+		}
+	}
+	if _, ok := vars["accrual_requests_total"]; !ok {
+		t.Error("expected accrual_requests_total in metrics")
+	}
+}
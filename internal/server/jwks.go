@@ -0,0 +1,17 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JWKSHandler публикует открытые ключи подписи, чтобы другие сервисы проверяли наши токены без общего секрета.
+// При одних HMAC-ключах список пуст
+func (s *Server) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// ключи меняются только при перезапуске с новой связкой; кеш короче, чем срок жизни старого ключа при ротации
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(s.deps.TokenManager.JWKS()); err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	srv, _ := setup(t)
+
+	// с HMAC-ключом публиковать нечего
+	w := httptest.NewRecorder()
+	srv.buildRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"keys":[]}`, w.Body.String())
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "ed.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	kr, err := auth.ParseKeyring("ed-1:@" + path)
+	require.NoError(t, err)
+	srv.deps.TokenManager = auth.NewKeyringTokenManager(kr, auth.TokenConfig{AccessTTL: time.Minute})
+
+	w = httptest.NewRecorder()
+	srv.buildRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var set auth.JWKSet
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	require.Equal(t, "ed-1", set.Keys[0].Kid)
+	require.Equal(t, "EdDSA", set.Keys[0].Alg)
+	require.Equal(t, "OKP", set.Keys[0].Kty)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/and161185/loyalty/internal/metrics"
+	"github.com/and161185/loyalty/internal/model"
+)
+
+// defaultLoginLockout — блокировка, если в конфигурации она не задана
+const defaultLoginLockout = 15 * time.Minute
+
+// loginDelay — пауза после второй неудачи по логину, дальше удваивается
+const loginDelay = time.Second
+
+// loginSweepInterval и loginSweepBatch — как часто и какими порциями удаляются устаревшие счётчики попыток
+const (
+	loginSweepInterval = time.Minute
+	loginSweepBatch    = 1000
+)
+
+// loginGuard — ключи, по которым считаются попытки входа одного запроса
+type loginGuard struct {
+	login string
+	ip    string
+}
+
+// loginKey — ключ учёта попыток и его ограничение
+type loginKey struct {
+	key   string
+	limit model.LoginLimit
+}
+
+func newLoginGuard(r *http.Request, login string) loginGuard {
+	// X-Forwarded-For подделывается клиентом, поэтому адрес берём только из соединения;
+	// за балансировщиком RemoteAddr должен выставлять он сам
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return loginGuard{login: "login:" + login, ip: "ip:" + host}
+}
+
+func (s *Server) loginLockout() time.Duration {
+	if s.config.LoginLockout > 0 {
+		return s.config.LoginLockout
+	}
+	return defaultLoginLockout
+}
+
+// loginKeys — ключи, для которых учёт включён. Адрес идёт первым: с заблокированного адреса
+// попытки не доходят до счёта по логину, и чужой логин так не заблокировать.
+// По логину каждая неудача начиная со второй придерживает вход на удваивающуюся паузу,
+// по адресу — только полная блокировка, чтобы пользователи за одним NAT не тормозили друг друга
+func (s *Server) loginKeys(g loginGuard) []loginKey {
+	lockout := s.loginLockout()
+
+	var keys []loginKey
+	if s.config.LoginIPMaxFailures > 0 {
+		keys = append(keys, loginKey{key: g.ip, limit: model.LoginLimit{
+			MaxFailures: s.config.LoginIPMaxFailures,
+			Lockout:     lockout,
+		}})
+	}
+	if s.config.LoginMaxFailures > 0 {
+		keys = append(keys, loginKey{key: g.login, limit: model.LoginLimit{
+			MaxFailures: s.config.LoginMaxFailures,
+			Lockout:     lockout,
+			Delay:       loginDelay,
+		}})
+	}
+	return keys
+}
+
+// admitLogin засчитывает попытку по всем ключам до проверки пароля, иначе параллельные запросы
+// успели бы проверить пароль раньше, чем кто-то из них запишет неудачу. Возвращает, сколько ждать,
+// если какой-то ключ заблокирован; попытка тогда не проверяется
+func (s *Server) admitLogin(ctx context.Context, g loginGuard) (time.Duration, error) {
+	now := s.now()
+	for _, k := range s.loginKeys(g) {
+		attempts, admitted, err := s.loginStorage.RecordLoginAttempt(ctx, k.key, k.limit, now)
+		if err != nil {
+			return 0, fmt.Errorf("record login attempt: %w", err)
+		}
+		if !admitted {
+			return attempts.LockedUntil.Sub(now), nil
+		}
+		if attempts.Failures == k.limit.MaxFailures {
+			metrics.LoginLockouts.Add(1)
+		}
+	}
+	return 0, nil
+}
+
+// loginSucceeded убирает удачную попытку из счёта: по логину он начинается заново,
+// с адреса снимается только эта попытка
+func (s *Server) loginSucceeded(ctx context.Context, g loginGuard) error {
+	if s.config.LoginMaxFailures > 0 {
+		if err := s.loginStorage.ResetLoginAttempts(ctx, g.login); err != nil {
+			return fmt.Errorf("reset login attempts: %w", err)
+		}
+	}
+	if s.config.LoginIPMaxFailures > 0 {
+		if err := s.loginStorage.ForgiveLoginAttempt(ctx, g.ip); err != nil {
+			return fmt.Errorf("forgive login attempt: %w", err)
+		}
+	}
+	return nil
+}
+
+// SweepLoginAttempts периодически удаляет счётчики, которые не обновлялись дольше блокировки,
+// пока не отменён ctx
+func (s *Server) SweepLoginAttempts(ctx context.Context) {
+	if s.config.LoginMaxFailures <= 0 && s.config.LoginIPMaxFailures <= 0 {
+		return
+	}
+
+	for sleepCtx(ctx, loginSweepInterval) {
+		if err := s.deleteStaleLoginAttempts(ctx); err != nil && ctx.Err() == nil {
+			s.deps.Logger.Errorf("sweep login attempts: %v", err)
+		}
+	}
+}
+
+// deleteStaleLoginAttempts удаляет устаревшие счётчики порциями, чтобы не держать таблицу одним большим запросом
+func (s *Server) deleteStaleLoginAttempts(ctx context.Context) error {
+	now := s.now()
+	before := now.Add(-s.loginLockout())
+	for {
+		deleted, err := s.loginStorage.DeleteStaleLoginAttempts(ctx, before, now, loginSweepBatch)
+		if err != nil {
+			return fmt.Errorf("delete stale login attempts: %w", err)
+		}
+		if deleted < loginSweepBatch {
+			return nil
+		}
+	}
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many login attempts", http.StatusTooManyRequests)
+}
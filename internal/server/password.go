@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/and161185/loyalty/internal/auth"
+	"github.com/and161185/loyalty/internal/middleware"
+	"github.com/and161185/loyalty/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (s *Server) passwordPolicy() auth.PasswordPolicy {
+	return auth.PasswordPolicy{
+		MinLength:    s.config.PasswordMinLength,
+		MinClasses:   s.config.PasswordMinClasses,
+		RejectCommon: s.config.PasswordRejectCommon,
+	}
+}
+
+// ChangePasswordHandler меняет пароль по старому паролю и завершает все сессии пользователя;
+// вызвавшему выдаётся новая пара токенов. Проверка старого пароля считается попыткой входа
+func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(middleware.UserContextKey).(model.User)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.OldPassword == "" || req.NewPassword == "" {
+		http.Error(w, "old and new password required", http.StatusBadRequest)
+		return
+	}
+
+	guard := newLoginGuard(r, user.Login)
+	wait, err := s.admitLogin(r.Context(), guard)
+	if err != nil {
+		s.deps.Logger.Errorf("%v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	_, hash, err := s.userStorage.GetUserByLogin(r.Context(), user.Login)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.OldPassword)) != nil {
+		http.Error(w, "invalid credentials", http.StatusForbidden)
+		return
+	}
+	if err := s.loginSucceeded(r.Context(), guard); err != nil {
+		s.deps.Logger.Errorf("%v", err)
+	}
+
+	if req.NewPassword == req.OldPassword {
+		http.Error(w, "new password must differ from the old one", http.StatusBadRequest)
+		return
+	}
+	if err := s.passwordPolicy().Validate(user.Login, req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "hash error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.userStorage.UpdatePassword(r.Context(), user.ID, string(newHash)); err != nil {
+		s.deps.Logger.Errorf("update password: %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	// все прежние access-токены отвергаются по версии, refresh-токены отзываются явно
+	if err := s.sessionStorage.RevokeUserSessions(r.Context(), user.ID); err != nil {
+		s.deps.Logger.Errorf("revoke user sessions: %v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err = s.userStorage.GetUserByID(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := s.newSession(r.Context(), user)
+	if err != nil {
+		s.deps.Logger.Errorf("new session: %v", err)
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(w, tokens)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/auth"
+	"github.com/and161185/loyalty/internal/config"
+	"github.com/and161185/loyalty/internal/deps"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestChangePassword(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := &config.Config{
+		RefreshTokenTTL:      time.Hour,
+		PasswordMinLength:    8,
+		PasswordMinClasses:   2,
+		PasswordRejectCommon: true,
+	}
+	d := &deps.Deps{
+		Logger:       zaptest.NewLogger(t).Sugar(),
+		TokenManager: auth.NewTokenManager("password-secret", time.Minute),
+	}
+	router := NewServer(store, store, store, store, store, store, cfg, d).buildRouter()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	decode := func(w *httptest.ResponseRecorder) model.TokenPair {
+		t.Helper()
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var tokens model.TokenPair
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		return tokens
+	}
+
+	// слабые пароли при регистрации отвергаются
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/register", "", `{"login":"alice","password":"short1"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/register", "", `{"login":"alice","password":"password123"}`).Code)
+
+	first := decode(do(http.MethodPost, "/api/user/register", "", `{"login":"alice","password":"first-pass-1"}`))
+	second := decode(do(http.MethodPost, "/api/user/login", "", `{"login":"alice","password":"first-pass-1"}`))
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPut, "/api/user/password", "", `{"old_password":"first-pass-1","new_password":"second-pass-2"}`).Code)
+	require.Equal(t, http.StatusForbidden, do(http.MethodPut, "/api/user/password", first.AccessToken, `{"old_password":"wrong-pass-1","new_password":"second-pass-2"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/user/password", first.AccessToken, `{"old_password":"first-pass-1","new_password":"qwerty"}`).Code)
+	require.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/user/password", first.AccessToken, `{"old_password":"first-pass-1","new_password":"first-pass-1"}`).Code)
+
+	changed := decode(do(http.MethodPut, "/api/user/password", first.AccessToken, `{"old_password":"first-pass-1","new_password":"second-pass-2"}`))
+	require.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/orders", changed.AccessToken, "").Code)
+
+	// ни access-, ни refresh-токены прежних сессий больше не действуют, в том числе другой сессии
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/orders", first.AccessToken, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/orders", second.AccessToken, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+second.RefreshToken+`"}`).Code)
+	refreshed := decode(do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+changed.RefreshToken+`"}`))
+	require.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/orders", refreshed.AccessToken, "").Code)
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/login", "", `{"login":"alice","password":"first-pass-1"}`).Code)
+	decode(do(http.MethodPost, "/api/user/login", "", `{"login":"alice","password":"second-pass-2"}`))
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/and161185/loyalty/internal/config"
 	"github.com/and161185/loyalty/internal/deps"
 	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/metrics"
 	"github.com/and161185/loyalty/internal/middleware"
 	"github.com/and161185/loyalty/internal/model"
 	"github.com/and161185/loyalty/internal/utils"
@@ -24,36 +25,76 @@ type UserStorage interface {
 	CreateUser(ctx context.Context, login, passwordHash string) error
 	GetUserByLogin(ctx context.Context, login string) (model.User, string, error)
 	GetUserByID(ctx context.Context, id int) (model.User, error)
+	UpdatePassword(ctx context.Context, userID int, passwordHash string) error
 }
 
 type OrderStorage interface {
 	AddOrder(ctx context.Context, user model.User, order model.Order) (int, error)
 	GetUserOrders(ctx context.Context, user model.User) ([]model.Order, error)
+	GetUserOrder(ctx context.Context, user model.User, number string) (model.OrderDetails, error)
 	GetUnprocessedOrders(ctx context.Context) ([]model.Order, error)
+	ClaimUnprocessedOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]model.Order, error)
+	ReleaseOrder(ctx context.Context, owner string, number string) error
+	ScheduleNextCheck(ctx context.Context, number string, nextCheckAt time.Time) error
 	UpdateOrder(ctx context.Context, order model.Order) error
+	ReverseOrder(ctx context.Context, number string, reason string) error
 }
 
 type BalanceStorage interface {
 	GetUserBalance(ctx context.Context, user model.User) (model.Balance, error)
-	WithdrawBalance(ctx context.Context, user model.User, order string, sum float64) error
+	WithdrawBalance(ctx context.Context, user model.User, order string, sum model.Points) error
 	GetWithdrawals(ctx context.Context, user model.User) ([]model.Withdrawal, error)
+	GetWithdrawal(ctx context.Context, user model.User, order string) (model.Withdrawal, error)
+}
+
+type IdempotencyStorage interface {
+	ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error)
+	SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error
+	DeleteIdempotencyKey(ctx context.Context, userID int, key string) error
+}
+
+// SessionStorage — серверная сторона сессий: refresh-токены и отозванные access-токены
+type SessionStorage interface {
+	CreateRefreshToken(ctx context.Context, token model.RefreshToken) error
+	RotateRefreshToken(ctx context.Context, hash string, next model.RefreshToken) (int, error)
+	RevokeRefreshToken(ctx context.Context, userID int, hash string) error
+	RevokeUserSessions(ctx context.Context, userID int) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// LoginAttemptStorage — неудачные входы, общие для всех реплик
+type LoginAttemptStorage interface {
+	RecordLoginAttempt(ctx context.Context, key string, limit model.LoginLimit, now time.Time) (model.LoginAttempts, bool, error)
+	ForgiveLoginAttempt(ctx context.Context, key string) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before, now time.Time, limit int) (int, error)
 }
 
 type Server struct {
-	userStorage    UserStorage
-	orderStorage   OrderStorage
-	balanceStorage BalanceStorage
-	config         *config.Config
-	deps           *deps.Deps
+	userStorage        UserStorage
+	orderStorage       OrderStorage
+	balanceStorage     BalanceStorage
+	idempotencyStorage IdempotencyStorage
+	sessionStorage     SessionStorage
+	loginStorage       LoginAttemptStorage
+	config             *config.Config
+	deps               *deps.Deps
+	// now — часы для учёта попыток входа; в тестах подменяются
+	now func() time.Time
 }
 
-func NewServer(userStorage UserStorage, orderStorage OrderStorage, balanceStorage BalanceStorage, config *config.Config, deps *deps.Deps) *Server {
+func NewServer(userStorage UserStorage, orderStorage OrderStorage, balanceStorage BalanceStorage, idempotencyStorage IdempotencyStorage, sessionStorage SessionStorage, loginStorage LoginAttemptStorage, config *config.Config, deps *deps.Deps) *Server {
 	return &Server{
-		userStorage:    userStorage,
-		orderStorage:   orderStorage,
-		balanceStorage: balanceStorage,
-		config:         config,
-		deps:           deps,
+		userStorage:        userStorage,
+		orderStorage:       orderStorage,
+		balanceStorage:     balanceStorage,
+		idempotencyStorage: idempotencyStorage,
+		sessionStorage:     sessionStorage,
+		loginStorage:       loginStorage,
+		config:             config,
+		deps:               deps,
+		now:                time.Now,
 	}
 }
 
@@ -64,23 +105,45 @@ func (s *Server) buildRouter() http.Handler {
 	router.Use(middleware.DecompressMiddleware)
 	router.Use(middleware.CompressMiddleware(s.deps.Logger))
 
+	router.Get("/health", s.HealthHandler)
+	router.Get("/.well-known/jwks.json", s.JWKSHandler)
+
+	// без секрета обратные вызовы не принимаем, статусы приходят только опросом
+	if s.config.WebhookSecret != "" {
+		signed := router.With(middleware.WebhookSignatureMiddleware([]byte(s.config.WebhookSecret)))
+		signed.Post("/api/internal/accrual/callback", s.AccrualCallbackHandler)
+		signed.Post("/api/internal/accrual/reversal", s.AccrualReversalHandler)
+	}
+
 	router.Post("/api/user/register", s.RegisterHandler)
 	router.Post("/api/user/login", s.LoginHandler)
+	router.Post("/api/user/token/refresh", s.RefreshTokenHandler)
 
 	// авторизованные ручки
 	router.Group(func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware(s.userStorage, s.deps.TokenManager))
+		r.Use(middleware.AuthMiddleware(s.userStorage, s.sessionStorage, s.deps.TokenManager))
+
+		idempotent := r.With(middleware.IdempotencyMiddleware(s.idempotencyStorage, s.deps.Logger))
 
-		r.Post("/api/user/orders", s.UploadOrderHandler)
+		idempotent.Post("/api/user/orders", s.UploadOrderHandler)
 		r.Get("/api/user/orders", s.GetOrdersHandler)
+		r.Get("/api/user/orders/{number}", s.GetOrderHandler)
 		r.Get("/api/user/balance", s.GetBalanceHandler)
-		r.Post("/api/user/balance/withdraw", s.WithdrawHandler)
+		idempotent.Post("/api/user/balance/withdraw", s.WithdrawHandler)
 		r.Get("/api/user/withdrawals", s.GetWithdrawalsHandler)
+		r.Post("/api/user/logout", s.LogoutHandler)
+		r.Put("/api/user/password", s.ChangePasswordHandler)
 	})
 
 	return router
 }
 
+func (s *Server) buildMetricsRouter() http.Handler {
+	router := chi.NewRouter()
+	router.Handle("/metrics", metrics.Handler())
+	return router
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	router := s.buildRouter()
 
@@ -95,13 +158,47 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
-	go s.OrdersStatusControl(ctx)
+	// метрики отдаются на отдельном внутреннем адресе, наружу их не выставляем
+	var metricsServer *http.Server
+	if s.config.MetricsAddress != "" {
+		metricsServer = &http.Server{
+			Addr:    s.config.MetricsAddress,
+			Handler: s.buildMetricsRouter(),
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.deps.Logger.Fatalf("metrics server error: %v", err)
+			}
+		}()
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		s.OrdersStatusControl(ctx)
+		close(workersDone)
+	}()
+
+	go s.SweepLoginAttempts(ctx)
 
 	<-ctx.Done()
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	return server.Shutdown(shutdownCtx)
+	err := server.Shutdown(shutdownCtx)
+	if metricsServer != nil {
+		if mErr := metricsServer.Shutdown(shutdownCtx); mErr != nil && err == nil {
+			err = mErr
+		}
+	}
+
+	// начатые проверки заказов доводим до конца, иначе начисление может потеряться до истечения аренды
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		s.deps.Logger.Warn("accrual workers did not finish before shutdown timeout")
+	}
+
+	return err
 }
 
 func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -118,6 +215,10 @@ func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "login and password required", http.StatusBadRequest)
 		return
 	}
+	if err := s.passwordPolicy().Validate(creds.Login, creds.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -141,14 +242,14 @@ func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := s.deps.TokenManager.GenerateToken(user.ID)
+	tokens, err := s.newSession(r.Context(), user)
 	if err != nil {
+		s.deps.Logger.Errorf("new session: %v", err)
 		http.Error(w, "token error", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
-	w.WriteHeader(http.StatusOK)
+	writeTokens(w, tokens)
 }
 
 func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -163,29 +264,41 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, hash, err := s.userStorage.GetUserByLogin(r.Context(), creds.Login)
+	guard := newLoginGuard(r, creds.Login)
+	wait, err := s.admitLogin(r.Context(), guard)
 	if err != nil {
-		if errors.Is(err, errs.ErrUserNotFound) {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
-			return
-		}
+		s.deps.Logger.Errorf("%v", err)
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	user, hash, err := s.userStorage.GetUserByLogin(r.Context(), creds.Login)
+	if err != nil && !errors.Is(err, errs.ErrUserNotFound) {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(creds.Password)); err != nil {
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(hash), []byte(creds.Password)) != nil {
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := s.deps.TokenManager.GenerateToken(user.ID)
+	if err := s.loginSucceeded(r.Context(), guard); err != nil {
+		s.deps.Logger.Errorf("%v", err)
+	}
+
+	tokens, err := s.newSession(r.Context(), user)
 	if err != nil {
+		s.deps.Logger.Errorf("new session: %v", err)
 		http.Error(w, "token error", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
-	w.WriteHeader(http.StatusOK)
+	writeTokens(w, tokens)
 }
 
 func (s *Server) UploadOrderHandler(w http.ResponseWriter, r *http.Request) {
@@ -241,6 +354,36 @@ func (s *Server) GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetOrderHandler отдаёт заказ пользователя вместе с историей смены статусов
+func (s *Server) GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(middleware.UserContextKey).(model.User)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	number := chi.URLParam(r, "number")
+	if !utils.IsValidLuhn(number) {
+		http.Error(w, "invalid order format", http.StatusUnprocessableEntity)
+		return
+	}
+
+	details, err := s.orderStorage.GetUserOrder(r.Context(), user, number)
+	if err != nil {
+		if errors.Is(err, errs.ErrOrderNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to get order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	user, ok := r.Context().Value(middleware.UserContextKey).(model.User)
 	if !ok {
@@ -283,6 +426,8 @@ func (s *Server) WithdrawHandler(w http.ResponseWriter, r *http.Request) {
 		switch {
 		case errors.Is(err, errs.ErrInsufficientFunds):
 			http.Error(w, "insufficient funds", http.StatusPaymentRequired)
+		case errors.Is(err, errs.ErrWithdrawalExists):
+			s.writeExistingWithdrawal(w, r, user, req.Order)
 		default:
 			http.Error(w, "withdraw failed", http.StatusInternalServerError)
 		}
@@ -315,3 +460,22 @@ func (s *Server) GetWithdrawalsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "encode error", http.StatusInternalServerError)
 	}
 }
+
+// writeExistingWithdrawal отвечает 409 и, если списание принадлежит пользователю, возвращает его в теле
+func (s *Server) writeExistingWithdrawal(w http.ResponseWriter, r *http.Request, user model.User, order string) {
+	withdrawal, err := s.balanceStorage.GetWithdrawal(r.Context(), user, order)
+	if err != nil {
+		if errors.Is(err, errs.ErrWithdrawalNotFound) {
+			http.Error(w, "withdrawal already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	if err := json.NewEncoder(w).Encode(withdrawal); err != nil {
+		s.deps.Logger.Errorf("encode withdrawal: %v", err)
+	}
+}
@@ -11,9 +11,11 @@ import (
 	"github.com/and161185/loyalty/internal/auth"
 	"github.com/and161185/loyalty/internal/config"
 	"github.com/and161185/loyalty/internal/deps"
+	"github.com/and161185/loyalty/internal/errs"
 	"github.com/and161185/loyalty/internal/middleware"
 	"github.com/and161185/loyalty/internal/mocks"
 	"github.com/and161185/loyalty/internal/model"
+	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
 	"go.uber.org/zap/zaptest"
 	"golang.org/x/crypto/bcrypt"
@@ -26,13 +28,13 @@ func setup(t *testing.T) (*Server, *mocks.MockStorage) {
 	mockStorage := mocks.NewMockStorage(ctrl)
 
 	logger := zaptest.NewLogger(t)
-	cfg := &config.Config{}
+	cfg := &config.Config{RefreshTokenTTL: time.Hour}
 	deps := &deps.Deps{
-		TokenManager: auth.NewTokenManager("testsecret"),
+		TokenManager: auth.NewTokenManager("testsecret", time.Hour),
 		Logger:       logger.Sugar(),
 	}
 
-	srv := NewServer(mockStorage, mockStorage, mockStorage, cfg, deps)
+	srv := NewServer(mockStorage, mockStorage, mockStorage, mockStorage, mockStorage, mockStorage, cfg, deps)
 
 	return srv, mockStorage
 }
@@ -54,6 +56,10 @@ func TestRegisterHandler(t *testing.T) {
 		GetUserByLogin(gomock.Any(), "user").
 		Return(model.User{ID: 1, Login: "user"}, "", nil)
 
+	mock.EXPECT().
+		CreateRefreshToken(gomock.Any(), gomock.Any()).
+		Return(nil)
+
 	payload := `{"login":"user","password":"pass"}`
 	req := httptest.NewRequest("POST", "/api/user/register", strings.NewReader(payload))
 	req.Header.Set("Content-Type", "application/json")
@@ -81,6 +87,10 @@ func TestLoginHandler(t *testing.T) {
 		GetUserByLogin(gomock.Any(), "user").
 		Return(model.User{ID: 1, Login: "user"}, pw, nil)
 
+	mock.EXPECT().
+		CreateRefreshToken(gomock.Any(), gomock.Any()).
+		Return(nil)
+
 	payload := `{"login":"user","password":"pass"}`
 	req := httptest.NewRequest("POST", "/api/user/login", strings.NewReader(payload))
 	w := httptest.NewRecorder()
@@ -103,7 +113,7 @@ func TestUploadOrderHandler(t *testing.T) {
 		AddOrder(gomock.Any(), gomock.Any(), model.Order{Number: order}).
 		Return(http.StatusAccepted, nil)
 
-	token, _ := srv.deps.TokenManager.GenerateToken(1)
+	token, _ := srv.deps.TokenManager.GenerateToken(1, 0)
 	req := newAuthenticatedRequest("POST", "/api/user/orders", token, order)
 	ctx := context.WithValue(req.Context(), middleware.UserContextKey, model.User{ID: 1})
 	req = req.WithContext(ctx)
@@ -128,7 +138,7 @@ func TestGetOrdersHandler(t *testing.T) {
 			{Number: "1", Status: "PROCESSED", UploadedAt: time.Now()},
 		}, nil)
 
-	token, _ := srv.deps.TokenManager.GenerateToken(1)
+	token, _ := srv.deps.TokenManager.GenerateToken(1, 0)
 	req := newAuthenticatedRequest("GET", "/api/user/orders", token, "")
 	ctx := context.WithValue(req.Context(), middleware.UserContextKey, model.User{ID: 1})
 	req = req.WithContext(ctx)
@@ -149,9 +159,9 @@ func TestGetBalanceHandler(t *testing.T) {
 
 	mock.EXPECT().
 		GetUserBalance(gomock.Any(), model.User{ID: 1}).
-		Return(model.Balance{Current: 100.0, Withdrawn: 50.0}, nil)
+		Return(model.Balance{Current: 100 * model.Point, Withdrawn: 50 * model.Point}, nil)
 
-	token, _ := srv.deps.TokenManager.GenerateToken(1)
+	token, _ := srv.deps.TokenManager.GenerateToken(1, 0)
 	req := newAuthenticatedRequest("GET", "/api/user/balance", token, "")
 	ctx := context.WithValue(req.Context(), middleware.UserContextKey, model.User{ID: 1})
 	req = req.WithContext(ctx)
@@ -171,11 +181,11 @@ func TestWithdrawHandler(t *testing.T) {
 	srv, mock := setup(t)
 
 	mock.EXPECT().
-		WithdrawBalance(gomock.Any(), model.User{ID: 1}, "12345678903", 50.0).
+		WithdrawBalance(gomock.Any(), model.User{ID: 1}, "12345678903", 50*model.Point).
 		Return(nil)
 
 	reqBody := `{"order":"12345678903","sum":50}`
-	token, _ := srv.deps.TokenManager.GenerateToken(1)
+	token, _ := srv.deps.TokenManager.GenerateToken(1, 0)
 	req := newAuthenticatedRequest("POST", "/api/user/balance/withdraw", token, reqBody)
 	ctx := context.WithValue(req.Context(), middleware.UserContextKey, model.User{ID: 1})
 	req = req.WithContext(ctx)
@@ -191,16 +201,49 @@ func TestWithdrawHandler(t *testing.T) {
 	}
 }
 
+func TestWithdrawHandlerDuplicate(t *testing.T) {
+	srv, mock := setup(t)
+
+	processedAt := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	mock.EXPECT().
+		WithdrawBalance(gomock.Any(), model.User{ID: 1}, "12345678903", 50*model.Point).
+		Return(errs.ErrWithdrawalExists)
+	mock.EXPECT().
+		GetWithdrawal(gomock.Any(), model.User{ID: 1}, "12345678903").
+		Return(model.Withdrawal{Order: "12345678903", Sum: 50 * model.Point, ProcessedAt: processedAt}, nil)
+
+	reqBody := `{"order":"12345678903","sum":50}`
+	token, _ := srv.deps.TokenManager.GenerateToken(1, 0)
+	req := newAuthenticatedRequest("POST", "/api/user/balance/withdraw", token, reqBody)
+	ctx := context.WithValue(req.Context(), middleware.UserContextKey, model.User{ID: 1})
+	req = req.WithContext(ctx)
+
+	w := httptest.NewRecorder()
+	srv.WithdrawHandler(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409, got %d", resp.StatusCode)
+	}
+
+	expected := `{"order":"12345678903","sum":50,"processed_at":"2025-07-01T12:00:00Z"}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
 func TestGetWithdrawalsHandler(t *testing.T) {
 	srv, mock := setup(t)
 
 	mock.EXPECT().
 		GetWithdrawals(gomock.Any(), model.User{ID: 1}).
 		Return([]model.Withdrawal{
-			{Order: "123", Sum: 10.5, ProcessedAt: time.Now()},
+			{Order: "123", Sum: 1050, ProcessedAt: time.Now()},
 		}, nil)
 
-	token, _ := srv.deps.TokenManager.GenerateToken(1)
+	token, _ := srv.deps.TokenManager.GenerateToken(1, 0)
 	req := newAuthenticatedRequest("GET", "/api/user/withdrawals", token, "")
 	ctx := context.WithValue(req.Context(), middleware.UserContextKey, model.User{ID: 1})
 	req = req.WithContext(ctx)
@@ -220,3 +263,62 @@ func bcryptHash(pw string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(pw), 10)
 	return string(hash), err
 }
+
+func newOrderRequest(number string) *http.Request {
+	req := httptest.NewRequest("GET", "/api/user/orders/"+number, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("number", number)
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, middleware.UserContextKey, model.User{ID: 1})
+	return req.WithContext(ctx)
+}
+
+func TestGetOrderHandler(t *testing.T) {
+	srv, mock := setup(t)
+
+	uploadedAt := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	accrual := 500 * model.Point
+	mock.EXPECT().
+		GetUserOrder(gomock.Any(), model.User{ID: 1}, "12345678903").
+		Return(model.OrderDetails{
+			Order: model.Order{Number: "12345678903", Status: model.Processed, Accrual: &accrual, UploadedAt: uploadedAt},
+			History: []model.OrderStatusChange{
+				{Status: model.New, ChangedAt: uploadedAt},
+				{Status: model.Processed, Accrual: &accrual, ChangedAt: uploadedAt.Add(time.Minute)},
+			},
+		}, nil)
+
+	w := httptest.NewRecorder()
+	srv.GetOrderHandler(w, newOrderRequest("12345678903"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	expected := `{"number":"12345678903","status":"PROCESSED","accrual":500,"uploaded_at":"2025-07-01T12:00:00Z",` +
+		`"history":[{"status":"NEW","changed_at":"2025-07-01T12:00:00Z"},` +
+		`{"status":"PROCESSED","accrual":500,"changed_at":"2025-07-01T12:01:00Z"}]}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestGetOrderHandlerErrors(t *testing.T) {
+	srv, mock := setup(t)
+
+	mock.EXPECT().
+		GetUserOrder(gomock.Any(), model.User{ID: 1}, "79927398713").
+		Return(model.OrderDetails{}, errs.ErrOrderNotFound)
+
+	w := httptest.NewRecorder()
+	srv.GetOrderHandler(w, newOrderRequest("79927398713"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.GetOrderHandler(w, newOrderRequest("12345"))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d", w.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/and161185/loyalty/internal/auth"
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/middleware"
+	"github.com/and161185/loyalty/internal/model"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newSession выдаёт пару токенов при входе; refresh-токен открывает новое семейство,
+// его хеш служит идентификатором семейства
+func (s *Server) newSession(ctx context.Context, user model.User) (model.TokenPair, error) {
+	refresh, err := auth.NewRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	hash := auth.HashRefreshToken(refresh)
+	err = s.sessionStorage.CreateRefreshToken(ctx, model.RefreshToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		FamilyID:  hash,
+		ExpiresAt: time.Now().Add(s.config.RefreshTokenTTL),
+	})
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	return s.tokenPair(user, refresh)
+}
+
+func (s *Server) tokenPair(user model.User, refresh string) (model.TokenPair, error) {
+	access, err := s.deps.TokenManager.GenerateToken(user.ID, user.TokenVersion)
+	if err != nil {
+		return model.TokenPair{}, fmt.Errorf("generate access token: %w", err)
+	}
+
+	return model.TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(s.deps.TokenManager.AccessTTL().Seconds()),
+	}, nil
+}
+
+// writeTokens отдаёт access-токен и в заголовке Authorization, как раньше, и в теле вместе с refresh-токеном
+func writeTokens(w http.ResponseWriter, tokens model.TokenPair) {
+	w.Header().Set("Authorization", "Bearer "+tokens.AccessToken)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+	}
+}
+
+// RefreshTokenHandler меняет refresh-токен на новую пару; предъявленный токен после этого недействителен
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	refresh, err := auth.NewRefreshToken()
+	if err != nil {
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	next := model.RefreshToken{
+		Hash:      auth.HashRefreshToken(refresh),
+		ExpiresAt: time.Now().Add(s.config.RefreshTokenTTL),
+	}
+	userID, err := s.sessionStorage.RotateRefreshToken(r.Context(), auth.HashRefreshToken(req.RefreshToken), next)
+	if err != nil {
+		switch {
+		case errors.Is(err, errs.ErrRefreshTokenReused):
+			s.deps.Logger.Warnf("refresh token reused, session revoked")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case errors.Is(err, errs.ErrInvalidToken):
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		default:
+			s.deps.Logger.Errorf("rotate refresh token: %v", err)
+			http.Error(w, "token error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// версия токенов нужна свежая: пароль мог смениться с момента входа
+	user, err := s.userStorage.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, errs.ErrUserNotFound) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := s.tokenPair(user, refresh)
+	if err != nil {
+		http.Error(w, "token error", http.StatusInternalServerError)
+		return
+	}
+
+	writeTokens(w, tokens)
+}
+
+// LogoutHandler отзывает access-токен запроса и, если передан, refresh-токен вместе со всем его семейством
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(middleware.UserContextKey).(model.User)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	claims, ok := r.Context().Value(middleware.ClaimsContextKey).(auth.Claims)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// тело необязательно: без него завершается только текущий access-токен
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.sessionStorage.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt); err != nil {
+		s.deps.Logger.Errorf("revoke access token: %v", err)
+		http.Error(w, "logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		err := s.sessionStorage.RevokeRefreshToken(r.Context(), user.ID, auth.HashRefreshToken(req.RefreshToken))
+		if err != nil {
+			s.deps.Logger.Errorf("revoke refresh token: %v", err)
+			http.Error(w, "logout failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/auth"
+	"github.com/and161185/loyalty/internal/config"
+	"github.com/and161185/loyalty/internal/deps"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	cfg := &config.Config{RefreshTokenTTL: time.Hour}
+	d := &deps.Deps{
+		Logger:       zaptest.NewLogger(t).Sugar(),
+		TokenManager: auth.NewTokenManager("session-secret", time.Minute),
+	}
+	router := NewServer(store, store, store, store, store, store, cfg, d).buildRouter()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	decode := func(w *httptest.ResponseRecorder) model.TokenPair {
+		t.Helper()
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var tokens model.TokenPair
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		require.NotEmpty(t, tokens.AccessToken)
+		require.NotEmpty(t, tokens.RefreshToken)
+		require.Equal(t, 60, tokens.ExpiresIn)
+		return tokens
+	}
+
+	first := decode(do(http.MethodPost, "/api/user/register", "", `{"login":"alice","password":"secret"}`))
+	require.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/orders", first.AccessToken, "").Code)
+
+	second := decode(do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`))
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	// повтор уже использованного refresh-токена закрывает сессию целиком
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+first.RefreshToken+`"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+second.RefreshToken+`"}`).Code)
+
+	third := decode(do(http.MethodPost, "/api/user/login", "", `{"login":"alice","password":"secret"}`))
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/api/user/logout", third.AccessToken, `{"refresh_token":"`+third.RefreshToken+`"}`).Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/orders", third.AccessToken, "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/user/token/refresh", "", `{"refresh_token":"`+third.RefreshToken+`"}`).Code)
+
+	// другие сессии выход не затрагивает
+	require.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/orders", second.AccessToken, "").Code)
+}
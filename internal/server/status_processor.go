@@ -2,119 +2,189 @@ package server
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
+	"math/rand/v2"
 	"time"
 
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/metrics"
 	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/workerpool"
 )
 
+// OrdersStatusControl опрашивает систему начислений, пока не отменён ctx,
+// и возвращается, когда воркеры закончат начатые заказы
 func (s *Server) OrdersStatusControl(ctx context.Context) {
-	workerCount := 5
+	pool := workerpool.New(s.config.AccrualWorkers, func(o model.Order) string { return o.Number }, s.handleOrder)
+	pool.Start(ctx)
 
-	ch := make(chan model.Order, 10*workerCount)
-	go s.ProcessOrders(ctx, ch)
+	s.ProcessOrders(ctx, pool)
 
-	for i := 0; i < workerCount; i++ {
-		go s.UpdateOrder(ctx, ch)
-	}
+	pool.Close()
 }
 
-func (s *Server) ProcessOrders(ctx context.Context, ch chan model.Order) {
+// orderLease — на сколько экземпляр занимает заказ; если он упадёт, заказ подхватят другие после истечения аренды
+const orderLease = time.Minute
+
+// pollInterval — пауза между опросами, когда новых заказов нет или система начислений недоступна
+const pollInterval = time.Second
+
+// границы экспоненциальной задержки между проверками одного заказа
+const (
+	checkBackoffBase = time.Second
+	checkBackoffMax  = 10 * time.Minute
+)
+
+func (s *Server) ProcessOrders(ctx context.Context, pool *workerpool.Pool[model.Order]) {
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			orders, err := s.orderStorage.GetUnprocessedOrders(ctx)
-			if err != nil {
-				s.deps.Logger.Errorf("process orders: %v", err)
-				time.Sleep(1 * time.Second)
-				continue
+		// берём не больше, чем свободных воркеров, чтобы не держать аренду на заказах, до которых
+		// очередь дойдёт нескоро; пока система начислений недоступна, заказы не берём вовсе
+		free := pool.Free()
+		if free == 0 || s.accrualUnavailable() {
+			if !sleepCtx(ctx, pollInterval) {
+				return
 			}
-			skipped := 0
-			for _, order := range orders {
-				select {
-				case ch <- order:
-
-				default:
-					skipped++
-					if skipped%10 == 0 {
-						s.deps.Logger.Warnf("channel full, skipped %d orders", skipped)
-					}
-				}
-			}
-			time.Sleep(1 * time.Second)
+			continue
 		}
-	}
-}
 
-func (s *Server) UpdateOrder(ctx context.Context, ch chan model.Order) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case order := <-ch:
-			newStatusOrder, err := s.getStatus(ctx, order)
-			if err != nil {
-				s.deps.Logger.Errorf("get order status: %v", err)
-				continue
-			}
-			if newStatusOrder.Status == order.Status {
-				continue
+		orders, err := s.orderStorage.ClaimUnprocessedOrders(ctx, s.config.InstanceID, free, orderLease)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
-			err = s.orderStorage.UpdateOrder(ctx, newStatusOrder)
-			if err != nil {
-				s.deps.Logger.Errorf("update order: %v", err)
+			s.deps.Logger.Errorf("claim orders: %v", err)
+		}
+
+		for i, order := range orders {
+			// Submit ждёт свободного воркера; заказ, который уже в работе, пропускается
+			if _, err := pool.Submit(ctx, order); err != nil {
+				s.releaseOrders(context.WithoutCancel(ctx), orders[i:])
+				return
 			}
 		}
 
+		if len(orders) < free && !sleepCtx(ctx, pollInterval) {
+			return
+		}
 	}
 }
 
-func (s *Server) getStatus(ctx context.Context, order model.Order) (model.Order, error) {
-	url := fmt.Sprintf("%s/api/orders/%s", s.config.AccrualSystemAddress, order.Number)
+// sleepCtx возвращает false, если ctx отменили раньше, чем прошло d
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return order, fmt.Errorf("create request: %w", err)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return order, fmt.Errorf("send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	switch resp.StatusCode {
-	case http.StatusNoContent:
-		return order, nil
-	case http.StatusTooManyRequests:
-		if retry := resp.Header.Get("Retry-After"); retry != "" {
-			if sec, err := strconv.Atoi(retry); err == nil {
-				time.Sleep(time.Duration(sec) * time.Second)
-			}
+// releaseOrders отдаёт невзятые в работу заказы другим экземплярам, не дожидаясь конца аренды
+func (s *Server) releaseOrders(ctx context.Context, orders []model.Order) {
+	for _, order := range orders {
+		if err := s.orderStorage.ReleaseOrder(ctx, s.config.InstanceID, order.Number); err != nil {
+			s.deps.Logger.Errorf("release order: %v", err)
 		}
-		return order, fmt.Errorf("too many requests")
-	case http.StatusOK:
-		var response struct {
-			Order   string   `json:"order"`
-			Status  string   `json:"status"`
-			Accrual *float64 `json:"accrual,omitempty"`
+	}
+}
+
+func (s *Server) handleOrder(ctx context.Context, order model.Order) {
+	s.processOrder(ctx, order)
+
+	if err := s.orderStorage.ReleaseOrder(ctx, s.config.InstanceID, order.Number); err != nil {
+		s.deps.Logger.Errorf("release order: %v", err)
+	}
+}
+
+func (s *Server) processOrder(ctx context.Context, order model.Order) {
+	if maxAge := s.config.OrderMaxAge; maxAge > 0 && time.Since(order.UploadedAt) > maxAge {
+		s.expireOrder(ctx, order, maxAge)
+		return
+	}
+
+	info, err := s.deps.Accrual.GetOrder(ctx, order.Number)
+	if err != nil {
+		var rateErr *accrual.RateLimitError
+		switch {
+		case errors.Is(err, accrual.ErrNotRegistered):
+			// система начислений ещё не знает о заказе — просто проверим позже
+		case errors.As(err, &rateErr), errors.Is(err, accrual.ErrCircuitOpen), errors.Is(err, context.Canceled):
+			// проверки не было (пауза после 429 или разомкнутая цепь) — заказ вернётся в очередь без штрафа
+			s.deps.Logger.Warnf("get order status: %v", err)
+			return
+		default:
+			s.deps.Logger.Errorf("get order status: %v", err)
 		}
+		s.scheduleNextCheck(ctx, order)
+		return
+	}
 
-		err := json.NewDecoder(resp.Body).Decode(&response)
-		if err != nil {
-			return order, fmt.Errorf("decode response: %w", err)
+	status := model.FromAccrualStatus(info.Status)
+	if status != order.Status {
+		newStatusOrder := order
+		newStatusOrder.Status = status
+		newStatusOrder.Accrual = info.Accrual
+
+		err = s.applyStatus(ctx, newStatusOrder)
+		if err != nil && !errors.Is(err, errs.ErrIllegalStatusTransition) {
+			s.deps.Logger.Errorf("update order: %v", err)
 		}
+	}
 
-		order.Status = model.OrderStatus(response.Status)
-		order.Accrual = response.Accrual
-		return order, nil
+	if !status.IsFinal() {
+		s.scheduleNextCheck(ctx, order)
+	}
+}
 
-	default:
-		return order, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// applyStatus сохраняет новый статус заказа; недопустимый переход не применяется, а логируется и считается
+func (s *Server) applyStatus(ctx context.Context, order model.Order) error {
+	err := s.orderStorage.UpdateOrder(ctx, order)
+	if errors.Is(err, errs.ErrIllegalStatusTransition) {
+		metrics.IllegalStatusTransitions.Add(1)
+		s.deps.Logger.Warnf("order %s: %v", order.Number, err)
 	}
+	return err
+}
+
+func (s *Server) accrualUnavailable() bool {
+	return s.deps.Breaker != nil && s.deps.Breaker.State() == accrual.StateOpen
+}
+
+func (s *Server) scheduleNextCheck(ctx context.Context, order model.Order) {
+	nextCheckAt := time.Now().Add(checkBackoff(order.Attempts))
+	if err := s.orderStorage.ScheduleNextCheck(ctx, order.Number, nextCheckAt); err != nil {
+		s.deps.Logger.Errorf("schedule next check: %v", err)
+	}
+}
+
+func (s *Server) expireOrder(ctx context.Context, order model.Order, maxAge time.Duration) {
+	order.Status = model.Invalid
+	order.Accrual = nil
+	order.InvalidReason = fmt.Sprintf("accrual system gave no final status within %s", maxAge)
+
+	if err := s.applyStatus(ctx, order); err != nil {
+		if !errors.Is(err, errs.ErrIllegalStatusTransition) {
+			s.deps.Logger.Errorf("expire order: %v", err)
+		}
+		return
+	}
+	s.deps.Logger.Warnf("order %s marked INVALID: %s", order.Number, order.InvalidReason)
+}
+
+// checkBackoff — задержка перед следующей проверкой после attempts безрезультатных:
+// base*2^attempts с ограничением сверху и случайным разбросом в верхней половине, чтобы заказы
+// одной пачки не приходили в систему начислений одновременно
+func checkBackoff(attempts int) time.Duration {
+	delay := checkBackoffMax
+	if attempts < 20 {
+		delay = min(checkBackoffBase<<attempts, checkBackoffMax)
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
 }
@@ -2,86 +2,173 @@ package server
 
 import (
 	"context"
-	"encoding/json"
-	"net/http"
-	"net/http/httptest"
 	"testing"
 	"time"
 
-	"github.com/and161185/loyalty/internal/config"
+	"github.com/and161185/loyalty/internal/accrual"
+	"github.com/and161185/loyalty/internal/mocks"
 	"github.com/and161185/loyalty/internal/model"
+	"github.com/golang/mock/gomock"
 )
 
-func TestGetStatus_OK(t *testing.T) {
-	accrual := 50.5
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"order":   "1234567890",
-			"status":  "PROCESSED",
-			"accrual": accrual,
-		})
-	}))
-	defer ts.Close()
+func setupWithAccrual(t *testing.T) (*Server, *mocks.MockStorage, *mocks.MockAccrualClient) {
+	t.Helper()
 
-	cfg := &config.Config{AccrualSystemAddress: ts.URL}
-	srv := &Server{config: cfg}
-	order := model.Order{Number: "1234567890"}
+	srv, mockStorage := setup(t)
+	mockAccrual := mocks.NewMockAccrualClient(gomock.NewController(t))
+	srv.deps.Accrual = mockAccrual
 
-	updated, err := srv.getStatus(context.Background(), order)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
+	return srv, mockStorage, mockAccrual
+}
 
-	if updated.Status != model.Processed {
-		t.Errorf("expected status %s, got %s", model.Processed, updated.Status)
+func TestCheckBackoff(t *testing.T) {
+	for attempts := 0; attempts < 30; attempts++ {
+		delay := checkBackoff(attempts)
+
+		want := checkBackoffMax
+		if attempts < 20 {
+			want = min(checkBackoffBase<<attempts, checkBackoffMax)
+		}
+		if delay < want/2 || delay > want {
+			t.Errorf("attempts %d: delay %s out of [%s, %s]", attempts, delay, want/2, want)
+		}
 	}
+}
 
-	if updated.Accrual == nil || *updated.Accrual != accrual {
-		t.Errorf("expected accrual %.2f, got %v", accrual, updated.Accrual)
-	}
+func TestProcessOrder_NotRegisteredSchedulesNextCheck(t *testing.T) {
+	srv, mock, accrualMock := setupWithAccrual(t)
+	accrualMock.EXPECT().
+		GetOrder(gomock.Any(), "1234567890").
+		Return(accrual.OrderInfo{}, accrual.ErrNotRegistered)
+
+	order := model.Order{Number: "1234567890", Status: model.New, UploadedAt: time.Now(), Attempts: 3}
+
+	before := time.Now()
+	mock.EXPECT().
+		ScheduleNextCheck(gomock.Any(), "1234567890", gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ string, nextCheckAt time.Time) error {
+			if nextCheckAt.Before(before.Add(4*time.Second)) || nextCheckAt.After(time.Now().Add(8*time.Second)) {
+				t.Errorf("unexpected next check in %s", nextCheckAt.Sub(before))
+			}
+			return nil
+		})
+
+	srv.processOrder(context.Background(), order)
 }
 
-func TestGetStatus_TooManyRequests(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Retry-After", "1")
-		w.WriteHeader(http.StatusTooManyRequests)
-	}))
-	defer ts.Close()
+func TestProcessOrder_ProcessedIsNotRescheduled(t *testing.T) {
+	srv, mock, accrualMock := setupWithAccrual(t)
+	points := 500 * model.Point
+	accrualMock.EXPECT().
+		GetOrder(gomock.Any(), "1234567890").
+		Return(accrual.OrderInfo{Order: "1234567890", Status: model.Processed, Accrual: &points}, nil)
+
+	order := model.Order{Number: "1234567890", Status: model.New, UploadedAt: time.Now()}
+
+	mock.EXPECT().
+		UpdateOrder(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, o model.Order) error {
+			if o.Status != model.Processed || o.Accrual == nil || *o.Accrual != 500*model.Point {
+				t.Errorf("unexpected update %+v", o)
+			}
+			return nil
+		})
 
-	cfg := &config.Config{AccrualSystemAddress: ts.URL}
-	srv := &Server{config: cfg}
-	order := model.Order{Number: "1234567890"}
+	srv.processOrder(context.Background(), order)
+}
 
-	start := time.Now()
-	_, err := srv.getStatus(context.Background(), order)
-	duration := time.Since(start)
+func TestProcessOrder_RegisteredMapsToProcessing(t *testing.T) {
+	srv, mock, accrualMock := setupWithAccrual(t)
+	accrualMock.EXPECT().
+		GetOrder(gomock.Any(), "1234567890").
+		Return(accrual.OrderInfo{Order: "1234567890", Status: model.Registered}, nil)
+	mock.EXPECT().
+		UpdateOrder(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, o model.Order) error {
+			if o.Status != model.Processing {
+				t.Errorf("expected PROCESSING, got %s", o.Status)
+			}
+			return nil
+		})
+	mock.EXPECT().ScheduleNextCheck(gomock.Any(), "1234567890", gomock.Any()).Return(nil)
 
-	if err == nil {
-		t.Fatal("expected error, got nil")
-	}
+	srv.processOrder(context.Background(), model.Order{Number: "1234567890", Status: model.New, UploadedAt: time.Now()})
+}
 
-	if duration < time.Second {
-		t.Errorf("expected sleep of at least 1s, got %s", duration)
-	}
+func TestProcessOrder_ServerErrorSchedulesNextCheck(t *testing.T) {
+	srv, mock, accrualMock := setupWithAccrual(t)
+	accrualMock.EXPECT().
+		GetOrder(gomock.Any(), "1234567890").
+		Return(accrual.OrderInfo{}, &accrual.ServerError{StatusCode: 500})
+	mock.EXPECT().
+		ScheduleNextCheck(gomock.Any(), "1234567890", gomock.Any()).
+		Return(nil)
+
+	srv.processOrder(context.Background(), model.Order{Number: "1234567890", Status: model.New, UploadedAt: time.Now()})
 }
 
-func TestGetStatus_NoContent(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNoContent)
-	}))
-	defer ts.Close()
+func TestProcessOrder_RateLimitedIsNotPenalized(t *testing.T) {
+	srv, _, accrualMock := setupWithAccrual(t)
+	accrualMock.EXPECT().
+		GetOrder(gomock.Any(), "1234567890").
+		Return(accrual.OrderInfo{}, &accrual.RateLimitError{RetryAfter: time.Minute})
+
+	// ScheduleNextCheck не ожидается: попытка не засчитывается
+	srv.processOrder(context.Background(), model.Order{Number: "1234567890", Status: model.New, UploadedAt: time.Now(), Attempts: 2})
+}
 
-	cfg := &config.Config{AccrualSystemAddress: ts.URL}
-	srv := &Server{config: cfg}
-	order := model.Order{Number: "1234567890"}
+func TestProcessOrder_ExpiresOldOrder(t *testing.T) {
+	srv, mock, _ := setupWithAccrual(t)
+	srv.config.OrderMaxAge = time.Hour
+	order := model.Order{Number: "1234567890", Status: model.Processing, UploadedAt: time.Now().Add(-2 * time.Hour)}
+
+	mock.EXPECT().
+		UpdateOrder(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, o model.Order) error {
+			if o.Status != model.Invalid || o.InvalidReason == "" {
+				t.Errorf("unexpected update %+v", o)
+			}
+			return nil
+		})
 
-	updated, err := srv.getStatus(context.Background(), order)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
+	srv.processOrder(context.Background(), order)
+}
+
+func TestOrdersStatusControl_DrainsOnShutdown(t *testing.T) {
+	srv, mock, accrualMock := setupWithAccrual(t)
+	srv.config.AccrualWorkers = 1
+	srv.config.InstanceID = "test"
+
+	order := model.Order{Number: "12345678903", Status: model.New, UploadedAt: time.Now()}
 
-	if updated != order {
-		t.Errorf("expected unchanged order, got %+v", updated)
+	ctx, cancel := context.WithCancel(context.Background())
+	points := 10 * model.Point
+
+	gomock.InOrder(
+		mock.EXPECT().ClaimUnprocessedOrders(gomock.Any(), "test", 1, orderLease).Return([]model.Order{order}, nil),
+		mock.EXPECT().ClaimUnprocessedOrders(gomock.Any(), "test", gomock.Any(), orderLease).Return(nil, nil).AnyTimes(),
+	)
+
+	// отмена приходит, пока воркер ещё ждёт ответа системы начислений
+	accrualMock.EXPECT().
+		GetOrder(gomock.Any(), order.Number).
+		DoAndReturn(func(_ context.Context, number string) (accrual.OrderInfo, error) {
+			cancel()
+			time.Sleep(20 * time.Millisecond)
+			return accrual.OrderInfo{Order: number, Status: model.Processed, Accrual: &points}, nil
+		})
+	mock.EXPECT().UpdateOrder(gomock.Any(), gomock.Any()).Return(nil)
+	mock.EXPECT().ReleaseOrder(gomock.Any(), "test", order.Number).Return(nil)
+
+	done := make(chan struct{})
+	go func() {
+		srv.OrdersStatusControl(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OrdersStatusControl did not return after cancel")
 	}
 }
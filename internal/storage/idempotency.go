@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// ReserveIdempotencyKey занимает ключ за запросом до record.ExpiresAt. Если ключ уже занят, возвращает
+// существующую запись и false. Просроченную запись, в том числе незавершённую, занимает заново
+func (s *PostgresStorage) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error) {
+	const deleteExpiredQuery = `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND expires_at < NOW()
+	`
+
+	const insertQuery = `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO NOTHING
+	`
+
+	const selectQuery = `
+		SELECT user_id, key, fingerprint, status_code, content_type, COALESCE(body, ''::bytea), expires_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`
+
+	var existing model.IdempotencyRecord
+	created := false
+
+	err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, deleteExpiredQuery, record.UserID, record.Key); err != nil {
+			return fmt.Errorf("delete expired idempotency key: %w", err)
+		}
+
+		cmdTag, err := tx.Exec(ctx, insertQuery, record.UserID, record.Key, record.Fingerprint, record.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("insert idempotency key: %w", err)
+		}
+		if cmdTag.RowsAffected() == 1 {
+			created = true
+			return nil
+		}
+
+		err = tx.QueryRow(ctx, selectQuery, record.UserID, record.Key).Scan(
+			&existing.UserID, &existing.Key, &existing.Fingerprint, &existing.StatusCode,
+			&existing.ContentType, &existing.Body, &existing.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("select idempotency key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.IdempotencyRecord{}, false, err
+	}
+
+	if created {
+		return record, true, nil
+	}
+
+	return existing, false, nil
+}
+
+// SaveIdempotencyResponse сохраняет ответ и продлевает запись до record.ExpiresAt. Уже сохранённый
+// ответ не перезаписывается: если ключ заняли после истечения аренды, остаётся ответ того, кто успел первым
+func (s *PostgresStorage) SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error {
+	const query = `
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, body = $5, expires_at = $6
+		WHERE user_id = $1 AND key = $2 AND status_code = 0
+	`
+
+	_, err := s.db.Exec(ctx, query, record.UserID, record.Key, record.StatusCode, record.ContentType, record.Body, record.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("save idempotency response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	const query = `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`
+
+	_, err := s.db.Exec(ctx, query, userID, key)
+	if err != nil {
+		return fmt.Errorf("delete idempotency key: %w", err)
+	}
+
+	return nil
+}
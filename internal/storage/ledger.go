@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// postLedgerEntry записывает проводку и обновляет материализованный баланс пользователя.
+// Вызывается только внутри транзакции вместе с изменением, которое её породило.
+func postLedgerEntry(ctx context.Context, tx pgx.Tx, entry model.LedgerEntry) error {
+	const insertEntryQuery = `
+		WITH t AS (SELECT nextval('ledger_transaction_seq') AS id)
+		INSERT INTO ledger_entries (transaction_id, user_id, account, entry_type, amount, order_number, comment)
+		SELECT t.id, $1, a.account, $2, a.amount, NULLIF($4, ''), $5
+		FROM t, (VALUES ('user', $3::numeric), ('system', -$3::numeric)) AS a(account, amount)
+	`
+
+	const updateBalanceQuery = `
+		INSERT INTO user_balances (user_id, current, withdrawn)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			current = user_balances.current + EXCLUDED.current,
+			withdrawn = user_balances.withdrawn + EXCLUDED.withdrawn,
+			updated_at = NOW()
+	`
+
+	_, err := tx.Exec(ctx, insertEntryQuery,
+		entry.UserID, entry.Type, entry.Amount, entry.OrderNumber, entry.Comment)
+	if err != nil {
+		return fmt.Errorf("insert ledger entry: %w", err)
+	}
+
+	var withdrawn model.Points
+	if entry.Type.CountsAsWithdrawn() {
+		withdrawn = -entry.Amount
+	}
+
+	_, err = tx.Exec(ctx, updateBalanceQuery, entry.UserID, entry.Amount, withdrawn)
+	if err != nil {
+		return fmt.Errorf("update user balance: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetLedgerEntries(ctx context.Context, user model.User) ([]model.LedgerEntry, error) {
+	const query = `
+		SELECT id, transaction_id, user_id, entry_type, amount, COALESCE(order_number, ''), comment, created_at
+		FROM ledger_entries
+		WHERE user_id = $1 AND account = 'user'
+		ORDER BY id
+	`
+
+	rows, err := s.db.Query(ctx, query, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	var list []model.LedgerEntry
+	for rows.Next() {
+		var e model.LedgerEntry
+		err := rows.Scan(&e.ID, &e.TransactionID, &e.UserID, &e.Type, &e.Amount, &e.OrderNumber, &e.Comment, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan ledger entry: %w", err)
+		}
+		list = append(list, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return list, nil
+}
+
+// AdjustBalance — ручная корректировка баланса, amount со знаком
+func (s *PostgresStorage) AdjustBalance(ctx context.Context, user model.User, amount model.Points, comment string) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		return postLedgerEntry(ctx, tx, model.LedgerEntry{
+			UserID:  user.ID,
+			Type:    model.LedgerAdjustment,
+			Amount:  amount,
+			Comment: comment,
+		})
+	})
+}
+
+// ReconcileBalances возвращает пользователей, у которых материализованный баланс
+// расходится с журналом, либо проводки которых не сходятся в ноль
+func (s *PostgresStorage) ReconcileBalances(ctx context.Context) ([]int, error) {
+	const query = `
+		SELECT b.user_id
+		FROM user_balances b
+		LEFT JOIN (
+			SELECT user_id,
+				SUM(amount) AS current,
+				-SUM(amount) FILTER (WHERE entry_type IN ('WITHDRAWAL', 'REFUND')) AS withdrawn
+			FROM ledger_entries
+			WHERE account = 'user'
+			GROUP BY user_id
+		) l ON l.user_id = b.user_id
+		WHERE b.current <> COALESCE(l.current, 0) OR b.withdrawn <> COALESCE(l.withdrawn, 0)
+		UNION
+		SELECT MIN(user_id)
+		FROM ledger_entries
+		GROUP BY transaction_id
+		HAVING SUM(amount) <> 0
+		ORDER BY 1
+	`
+
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("reconcile balances: %w", err)
+	}
+	defer rows.Close()
+
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan user id: %w", err)
+		}
+		users = append(users, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return users, nil
+}
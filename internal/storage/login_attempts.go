@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// RecordLoginAttempt засчитывает попытку входа по ключу на момент now до проверки пароля. Заблокированный ключ
+// попытку не принимает: возвращается false и текущая блокировка. Иначе счёт растёт (или начинается
+// заново, если прошлая попытка была раньше limit.Lockout), ключ блокируется по limit.LockFor
+// и возвращается true. Строка ключа держится FOR UPDATE, так что параллельные попытки
+// засчитываются по одной. Устаревшие ключи здесь не трогаются, их удаляет DeleteStaleLoginAttempts
+func (s *PostgresStorage) RecordLoginAttempt(ctx context.Context, key string, limit model.LoginLimit, now time.Time) (model.LoginAttempts, bool, error) {
+	const ensureQuery = `INSERT INTO login_attempts (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`
+
+	const selectQuery = `SELECT failures, locked_until, updated_at FROM login_attempts WHERE key = $1 FOR UPDATE`
+
+	const updateQuery = `UPDATE login_attempts SET failures = $2, locked_until = $3, updated_at = $4 WHERE key = $1`
+
+	attempts := model.LoginAttempts{Key: key}
+	var admitted bool
+	windowStart := now.Add(-limit.Lockout)
+
+	err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, ensureQuery, key); err != nil {
+			return fmt.Errorf("insert login attempts: %w", err)
+		}
+
+		var lockedUntil *time.Time
+		var updatedAt time.Time
+		if err := tx.QueryRow(ctx, selectQuery, key).Scan(&attempts.Failures, &lockedUntil, &updatedAt); err != nil {
+			return fmt.Errorf("select login attempts: %w", err)
+		}
+		if lockedUntil != nil && lockedUntil.After(now) {
+			attempts.LockedUntil = *lockedUntil
+			return nil
+		}
+
+		admitted = true
+		attempts.Failures = nextFailures(attempts.Failures, updatedAt, windowStart)
+		var newLock *time.Time
+		if lock := limit.LockFor(attempts.Failures); lock > 0 {
+			attempts.LockedUntil = now.Add(lock)
+			newLock = &attempts.LockedUntil
+		}
+
+		if _, err := tx.Exec(ctx, updateQuery, key, attempts.Failures, newLock, now); err != nil {
+			return fmt.Errorf("record login attempt: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.LoginAttempts{}, false, err
+	}
+
+	return attempts, admitted, nil
+}
+
+// DeleteStaleLoginAttempts удаляет не больше limit ключей, которые не обновлялись с before
+// и не заблокированы на момент now, и возвращает, сколько удалено. Вызывается периодически,
+// а не при каждой попытке, чтобы входы не спорили за одни и те же устаревшие строки
+func (s *PostgresStorage) DeleteStaleLoginAttempts(ctx context.Context, before, now time.Time, limit int) (int, error) {
+	const query = `
+		DELETE FROM login_attempts
+		WHERE key IN (
+			SELECT key FROM login_attempts
+			WHERE updated_at < $1 AND (locked_until IS NULL OR locked_until < $2)
+			LIMIT $3
+		)
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, before, now, limit)
+	if err != nil {
+		return 0, fmt.Errorf("delete stale login attempts: %w", err)
+	}
+
+	return int(cmdTag.RowsAffected()), nil
+}
+
+// nextFailures — счёт после ещё одной попытки; попытки старше окна забываются
+func nextFailures(failures int, updatedAt, windowStart time.Time) int {
+	if updatedAt.Before(windowStart) {
+		failures = 0
+	}
+	return failures + 1
+}
+
+// ForgiveLoginAttempt снимает со счёта попытку, которая оказалась удачной
+func (s *PostgresStorage) ForgiveLoginAttempt(ctx context.Context, key string) error {
+	const query = `UPDATE login_attempts SET failures = GREATEST(failures - 1, 0) WHERE key = $1`
+
+	if _, err := s.db.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("forgive login attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) ResetLoginAttempts(ctx context.Context, key string) error {
+	const query = `DELETE FROM login_attempts WHERE key = $1`
+
+	if _, err := s.db.Exec(ctx, query, key); err != nil {
+		return fmt.Errorf("reset login attempts: %w", err)
+	}
+
+	return nil
+}
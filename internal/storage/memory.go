@@ -0,0 +1,733 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/model"
+)
+
+// MemoryStorage — хранилище в памяти для локального запуска и тестов, повторяет семантику PostgresStorage
+type MemoryStorage struct {
+	mu sync.Mutex
+
+	users        map[int]memoryUser
+	usersByLogin map[string]int
+	nextUserID   int
+
+	orders   map[string]memoryOrder
+	sequence int64
+
+	withdrawals []memoryWithdrawal
+
+	ledger       []model.LedgerEntry
+	nextLedgerID int64
+	balances     map[int]model.Balance
+
+	idempotency map[idempotencyKey]model.IdempotencyRecord
+
+	refreshTokens map[string]memoryRefreshToken
+	revokedTokens map[string]time.Time
+
+	loginAttempts map[string]memoryLoginAttempts
+}
+
+type memoryLoginAttempts struct {
+	attempts  model.LoginAttempts
+	updatedAt time.Time
+}
+
+type memoryRefreshToken struct {
+	token   model.RefreshToken
+	revoked bool
+}
+
+type memoryUser struct {
+	user         model.User
+	passwordHash string
+}
+
+type memoryOrder struct {
+	userID int
+	order  model.Order
+	seq    int64 // порядок вставки, чтобы сортировка при равном времени была стабильной
+
+	claimedBy    string
+	claimedUntil time.Time
+	nextCheckAt  time.Time
+
+	history []model.OrderStatusChange
+}
+
+type memoryWithdrawal struct {
+	userID     int
+	withdrawal model.Withdrawal
+	seq        int64
+}
+
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:        make(map[int]memoryUser),
+		usersByLogin: make(map[string]int),
+		orders:       make(map[string]memoryOrder),
+		balances:     make(map[int]model.Balance),
+		idempotency:  make(map[idempotencyKey]model.IdempotencyRecord),
+
+		refreshTokens: make(map[string]memoryRefreshToken),
+		revokedTokens: make(map[string]time.Time),
+		loginAttempts: make(map[string]memoryLoginAttempts),
+	}
+}
+
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) CreateUser(ctx context.Context, login string, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.usersByLogin[login]; ok {
+		return errs.ErrLoginAlreadyExists
+	}
+
+	s.nextUserID++
+	user := model.User{ID: s.nextUserID, Login: login}
+	s.users[user.ID] = memoryUser{user: user, passwordHash: passwordHash}
+	s.usersByLogin[login] = user.ID
+
+	return nil
+}
+
+func (s *MemoryStorage) GetUserByLogin(ctx context.Context, login string) (model.User, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.usersByLogin[login]
+	if !ok {
+		return model.User{}, "", errs.ErrUserNotFound
+	}
+
+	u := s.users[id]
+	return u.user, u.passwordHash, nil
+}
+
+func (s *MemoryStorage) GetUserByID(ctx context.Context, id int) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return model.User{}, errs.ErrUserNotFound
+	}
+
+	return u.user, nil
+}
+
+func (s *MemoryStorage) UpdatePassword(ctx context.Context, userID int, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return errs.ErrUserNotFound
+	}
+	u.passwordHash = passwordHash
+	u.user.TokenVersion++
+	s.users[userID] = u
+
+	return nil
+}
+
+func (s *MemoryStorage) AddOrder(ctx context.Context, user model.User, order model.Order) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.orders[order.Number]; ok {
+		if existing.userID == user.ID {
+			if existing.order.Status == model.Processing || existing.order.Status == model.Registered {
+				return 202, nil // Уже загружен этим пользователем
+			}
+			return 200, nil
+		}
+		return 409, nil // Загружен другим
+	}
+
+	now := time.Now()
+	s.sequence++
+	s.orders[order.Number] = memoryOrder{
+		userID: user.ID,
+		order: model.Order{
+			Number:     order.Number,
+			Status:     model.New,
+			UploadedAt: now,
+		},
+		seq:     s.sequence,
+		history: []model.OrderStatusChange{{Status: model.New, ChangedAt: now}},
+	}
+
+	return 202, nil // Новый заказ принят
+}
+
+func (s *MemoryStorage) GetUserOrder(ctx context.Context, user model.User, number string) (model.OrderDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok || o.userID != user.ID {
+		return model.OrderDetails{}, errs.ErrOrderNotFound
+	}
+
+	details := model.OrderDetails{
+		Order:   copyOrder(o.order),
+		History: make([]model.OrderStatusChange, 0, len(o.history)),
+	}
+	for _, change := range o.history {
+		change.Accrual = copyPoints(change.Accrual)
+		details.History = append(details.History, change)
+	}
+
+	return details, nil
+}
+
+func (s *MemoryStorage) GetUserOrders(ctx context.Context, user model.User) ([]model.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []memoryOrder
+	for _, o := range s.orders {
+		if o.userID == user.ID {
+			list = append(list, o)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].order.UploadedAt.Equal(list[j].order.UploadedAt) {
+			return list[i].order.UploadedAt.After(list[j].order.UploadedAt)
+		}
+		return list[i].seq > list[j].seq
+	})
+
+	var orders []model.Order
+	for _, o := range list {
+		order := copyOrder(o.order)
+		if order.Status == model.Reversed {
+			order.Accrual = nil
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+func (s *MemoryStorage) GetUnprocessedOrders(ctx context.Context) ([]model.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var list []memoryOrder
+	for _, o := range s.orders {
+		if (o.order.Status == model.New || o.order.Status == model.Processing) && !o.nextCheckAt.After(now) {
+			list = append(list, o)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].order.UploadedAt.Equal(list[j].order.UploadedAt) {
+			return list[i].order.UploadedAt.Before(list[j].order.UploadedAt)
+		}
+		return list[i].seq < list[j].seq
+	})
+
+	var orders []model.Order
+	for _, o := range list {
+		orders = append(orders, model.Order{Number: o.order.Number})
+	}
+
+	return orders, nil
+}
+
+func (s *MemoryStorage) ClaimUnprocessedOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]model.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var list []memoryOrder
+	for _, o := range s.orders {
+		if o.order.Status != model.New && o.order.Status != model.Processing {
+			continue
+		}
+		if o.nextCheckAt.After(now) {
+			continue
+		}
+		if o.claimedBy != "" && o.claimedUntil.After(now) {
+			continue
+		}
+		list = append(list, o)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].order.UploadedAt.Equal(list[j].order.UploadedAt) {
+			return list[i].order.UploadedAt.Before(list[j].order.UploadedAt)
+		}
+		return list[i].seq < list[j].seq
+	})
+
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	var orders []model.Order
+	for _, o := range list {
+		o.claimedBy = owner
+		o.claimedUntil = now.Add(lease)
+		s.orders[o.order.Number] = o
+
+		orders = append(orders, model.Order{
+			Number:     o.order.Number,
+			Status:     o.order.Status,
+			UploadedAt: o.order.UploadedAt,
+			Attempts:   o.order.Attempts,
+		})
+	}
+
+	return orders, nil
+}
+
+func (s *MemoryStorage) ScheduleNextCheck(ctx context.Context, number string, nextCheckAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return nil
+	}
+
+	o.order.Attempts++
+	o.nextCheckAt = nextCheckAt
+	s.orders[number] = o
+
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseOrder(ctx context.Context, owner string, number string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok || o.claimedBy != owner {
+		return nil
+	}
+
+	o.claimedBy = ""
+	o.claimedUntil = time.Time{}
+	s.orders[number] = o
+
+	return nil
+}
+
+func (s *MemoryStorage) UpdateOrder(ctx context.Context, order model.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.orders[order.Number]
+	if !ok {
+		return errs.ErrOrderNotFound
+	}
+	if !existing.order.Status.CanTransitionTo(order.Status) {
+		return rejectedTransition(existing.order.Status, order.Status)
+	}
+
+	existing.order.Status = order.Status
+	existing.order.InvalidReason = order.InvalidReason
+	existing.order.Accrual = copyPoints(order.Accrual)
+	existing.history = append(existing.history, model.OrderStatusChange{
+		Status:    order.Status,
+		Accrual:   copyPoints(order.Accrual),
+		Reason:    order.InvalidReason,
+		ChangedAt: time.Now(),
+	})
+	s.orders[order.Number] = existing
+
+	if order.Status == model.Processed && order.Accrual != nil && *order.Accrual != 0 {
+		s.postLedgerEntry(model.LedgerEntry{
+			UserID:      existing.userID,
+			Type:        model.LedgerAccrual,
+			Amount:      *order.Accrual,
+			OrderNumber: order.Number,
+		})
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) ReverseOrder(ctx context.Context, number string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.orders[number]
+	if !ok {
+		return errs.ErrOrderNotFound
+	}
+	if existing.order.Status != model.Processed {
+		return rejectedTransition(existing.order.Status, model.Reversed)
+	}
+
+	existing.order.Status = model.Reversed
+	existing.order.InvalidReason = reason
+	existing.history = append(existing.history, model.OrderStatusChange{
+		Status:    model.Reversed,
+		Accrual:   copyPoints(existing.order.Accrual),
+		Reason:    reason,
+		ChangedAt: time.Now(),
+	})
+	s.orders[number] = existing
+
+	if accrual := existing.order.Accrual; accrual != nil && *accrual != 0 {
+		s.postLedgerEntry(model.LedgerEntry{
+			UserID:      existing.userID,
+			Type:        model.LedgerReversal,
+			Amount:      -*accrual,
+			OrderNumber: number,
+			Comment:     reason,
+		})
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) GetUserBalance(ctx context.Context, user model.User) (model.Balance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.balances[user.ID], nil
+}
+
+func (s *MemoryStorage) WithdrawBalance(ctx context.Context, user model.User, order string, sum model.Points) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range s.withdrawals {
+		if w.withdrawal.Order == order {
+			return errs.ErrWithdrawalExists
+		}
+	}
+
+	if s.balances[user.ID].Current < sum {
+		return errs.ErrInsufficientFunds
+	}
+
+	s.sequence++
+	s.withdrawals = append(s.withdrawals, memoryWithdrawal{
+		userID: user.ID,
+		withdrawal: model.Withdrawal{
+			Order:       order,
+			Sum:         sum,
+			ProcessedAt: time.Now(),
+		},
+		seq: s.sequence,
+	})
+
+	s.postLedgerEntry(model.LedgerEntry{
+		UserID:      user.ID,
+		Type:        model.LedgerWithdrawal,
+		Amount:      -sum,
+		OrderNumber: order,
+	})
+
+	return nil
+}
+
+func (s *MemoryStorage) GetWithdrawals(ctx context.Context, user model.User) ([]model.Withdrawal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []memoryWithdrawal
+	for _, w := range s.withdrawals {
+		if w.userID == user.ID {
+			list = append(list, w)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].withdrawal.ProcessedAt.Equal(list[j].withdrawal.ProcessedAt) {
+			return list[i].withdrawal.ProcessedAt.After(list[j].withdrawal.ProcessedAt)
+		}
+		return list[i].seq > list[j].seq
+	})
+
+	var withdrawals []model.Withdrawal
+	for _, w := range list {
+		withdrawals = append(withdrawals, w.withdrawal)
+	}
+
+	return withdrawals, nil
+}
+
+func (s *MemoryStorage) GetWithdrawal(ctx context.Context, user model.User, order string) (model.Withdrawal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range s.withdrawals {
+		if w.userID == user.ID && w.withdrawal.Order == order {
+			return w.withdrawal, nil
+		}
+	}
+
+	return model.Withdrawal{}, errs.ErrWithdrawalNotFound
+}
+
+func (s *MemoryStorage) GetLedgerEntries(ctx context.Context, user model.User) ([]model.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []model.LedgerEntry
+	for _, e := range s.ledger {
+		if e.UserID == user.ID {
+			list = append(list, e)
+		}
+	}
+
+	return list, nil
+}
+
+func (s *MemoryStorage) AdjustBalance(ctx context.Context, user model.User, amount model.Points, comment string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.postLedgerEntry(model.LedgerEntry{
+		UserID:  user.ID,
+		Type:    model.LedgerAdjustment,
+		Amount:  amount,
+		Comment: comment,
+	})
+
+	return nil
+}
+
+// postLedgerEntry вызывается под s.mu
+func (s *MemoryStorage) postLedgerEntry(entry model.LedgerEntry) {
+	s.nextLedgerID++
+	entry.ID = s.nextLedgerID
+	entry.TransactionID = s.nextLedgerID
+	entry.CreatedAt = time.Now()
+	s.ledger = append(s.ledger, entry)
+
+	balance := s.balances[entry.UserID]
+	balance.Current += entry.Amount
+	if entry.Type.CountsAsWithdrawn() {
+		balance.Withdrawn -= entry.Amount
+	}
+	s.balances[entry.UserID] = balance
+}
+
+func (s *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := idempotencyKey{userID: record.UserID, key: record.Key}
+	if existing, ok := s.idempotency[key]; ok && existing.ExpiresAt.After(time.Now()) {
+		return existing, false, nil
+	}
+
+	s.idempotency[key] = record
+	return record, true, nil
+}
+
+func (s *MemoryStorage) SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := idempotencyKey{userID: record.UserID, key: record.Key}
+	if existing, ok := s.idempotency[key]; ok && existing.StatusCode == 0 {
+		s.idempotency[key] = record
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotency, idempotencyKey{userID: userID, key: key})
+	return nil
+}
+
+// copyOrder отдаёт заказ так же, как GetUserOrders в базе: без служебных полей опроса
+func copyOrder(o model.Order) model.Order {
+	o.Attempts = 0
+	o.InvalidReason = ""
+	o.Accrual = copyPoints(o.Accrual)
+	return o
+}
+
+func copyPoints(p *model.Points) *model.Points {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func (s *MemoryStorage) CreateRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshTokens[token.Hash] = memoryRefreshToken{token: token}
+	return nil
+}
+
+func (s *MemoryStorage) RotateRefreshToken(ctx context.Context, hash string, next model.RefreshToken) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.refreshTokens[hash]
+	if !ok {
+		return 0, errs.ErrInvalidToken
+	}
+	if current.revoked {
+		s.revokeFamily(current.token.FamilyID)
+		return 0, errs.ErrRefreshTokenReused
+	}
+	if !current.token.ExpiresAt.After(time.Now()) {
+		return 0, errs.ErrInvalidToken
+	}
+
+	current.revoked = true
+	s.refreshTokens[hash] = current
+
+	next.UserID = current.token.UserID
+	next.FamilyID = current.token.FamilyID
+	s.refreshTokens[next.Hash] = memoryRefreshToken{token: next}
+
+	return next.UserID, nil
+}
+
+func (s *MemoryStorage) RevokeRefreshToken(ctx context.Context, userID int, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.refreshTokens[hash]; ok && t.token.UserID == userID {
+		s.revokeFamily(t.token.FamilyID)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, t := range s.refreshTokens {
+		if t.token.UserID == userID {
+			t.revoked = true
+			s.refreshTokens[hash] = t
+		}
+	}
+	return nil
+}
+
+// revokeFamily вызывается под s.mu
+func (s *MemoryStorage) revokeFamily(familyID string) {
+	for hash, t := range s.refreshTokens {
+		if t.token.FamilyID == familyID {
+			t.revoked = true
+			s.refreshTokens[hash] = t
+		}
+	}
+}
+
+func (s *MemoryStorage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.revokedTokens {
+		if exp.Before(now) {
+			delete(s.revokedTokens, id)
+		}
+	}
+
+	s.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStorage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revokedTokens[jti]
+	return ok, nil
+}
+
+func (s *MemoryStorage) RecordLoginAttempt(ctx context.Context, key string, limit model.LoginLimit, now time.Time) (model.LoginAttempts, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windowStart := now.Add(-limit.Lockout)
+
+	a, ok := s.loginAttempts[key]
+	if !ok {
+		a.attempts.Key = key
+		a.updatedAt = now
+	}
+	if a.attempts.LockedUntil.After(now) {
+		return a.attempts, false, nil
+	}
+
+	a.attempts.Failures = nextFailures(a.attempts.Failures, a.updatedAt, windowStart)
+	a.attempts.LockedUntil = time.Time{}
+	if lock := limit.LockFor(a.attempts.Failures); lock > 0 {
+		a.attempts.LockedUntil = now.Add(lock)
+	}
+	a.updatedAt = now
+	s.loginAttempts[key] = a
+
+	return a.attempts, true, nil
+}
+
+func (s *MemoryStorage) DeleteStaleLoginAttempts(ctx context.Context, before, now time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for k, a := range s.loginAttempts {
+		if deleted == limit {
+			break
+		}
+		if a.updatedAt.Before(before) && !a.attempts.LockedUntil.After(now) {
+			delete(s.loginAttempts, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStorage) ForgiveLoginAttempt(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.loginAttempts[key]; ok && a.attempts.Failures > 0 {
+		a.attempts.Failures--
+		s.loginAttempts[key] = a
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return NewMemoryStorage()
+	})
+}
+
+func TestMemoryAddOrderCodes(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateUser(ctx, "alice", "hash"))
+	require.NoError(t, s.CreateUser(ctx, "bob", "hash"))
+	require.ErrorIs(t, s.CreateUser(ctx, "alice", "hash"), errs.ErrLoginAlreadyExists)
+
+	alice, _, err := s.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	bob, _, err := s.GetUserByLogin(ctx, "bob")
+	require.NoError(t, err)
+
+	code, err := s.AddOrder(ctx, alice, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 202, code)
+
+	code, err = s.AddOrder(ctx, alice, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 200, code)
+
+	code, err = s.AddOrder(ctx, bob, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 409, code)
+}
+
+func TestMemoryConcurrentWithdrawals(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateUser(ctx, "alice", "hash"))
+	user, _, err := s.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, s.AdjustBalance(ctx, user, 100*model.Point, "initial"))
+
+	const attempts = 50
+	var wg sync.WaitGroup
+	errCh := make(chan error, attempts)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errCh <- s.WithdrawBalance(ctx, user, fmt.Sprintf("order-%d", i), 10*model.Point)
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+
+	succeeded := 0
+	for err := range errCh {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		require.ErrorIs(t, err, errs.ErrInsufficientFunds)
+	}
+
+	require.Equal(t, 10, succeeded)
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0, Withdrawn: 100 * model.Point}, balance)
+}
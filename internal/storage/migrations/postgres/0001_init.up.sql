@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	login TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	number TEXT PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id),
+	status TEXT NOT NULL DEFAULT 'NEW',
+	accrual NUMERIC,
+	uploaded_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL REFERENCES users(id),
+	order_number TEXT NOT NULL,
+	sum NUMERIC NOT NULL,
+	processed_at TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_balances;
+DROP TABLE IF EXISTS ledger_entries;
+DROP FUNCTION IF EXISTS ledger_entries_append_only();
+DROP SEQUENCE IF EXISTS ledger_transaction_seq;
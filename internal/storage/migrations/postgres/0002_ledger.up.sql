@@ -0,0 +1,65 @@
+CREATE SEQUENCE ledger_transaction_seq;
+
+-- каждая проводка — две записи с одним transaction_id: по счёту пользователя и по системному счёту,
+-- сумма записей проводки всегда равна нулю
+CREATE TABLE ledger_entries (
+	id BIGSERIAL PRIMARY KEY,
+	transaction_id BIGINT NOT NULL,
+	user_id INT NOT NULL REFERENCES users(id),
+	account TEXT NOT NULL CHECK (account IN ('user', 'system')),
+	entry_type TEXT NOT NULL CHECK (entry_type IN ('ACCRUAL', 'WITHDRAWAL', 'ADJUSTMENT', 'REVERSAL')),
+	amount NUMERIC NOT NULL,
+	order_number TEXT,
+	comment TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX ledger_entries_user_idx ON ledger_entries (user_id, account, id);
+CREATE INDEX ledger_entries_transaction_idx ON ledger_entries (transaction_id);
+
+CREATE FUNCTION ledger_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entries_append_only
+	BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE FUNCTION ledger_entries_append_only();
+
+CREATE TABLE user_balances (
+	user_id INT PRIMARY KEY REFERENCES users(id),
+	current NUMERIC NOT NULL DEFAULT 0,
+	withdrawn NUMERIC NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- перенос существующих начислений и списаний в журнал
+CREATE TEMPORARY TABLE ledger_backfill AS
+SELECT nextval('ledger_transaction_seq') AS transaction_id, user_id, entry_type, amount, order_number, created_at
+FROM (
+	SELECT user_id, 'ACCRUAL' AS entry_type, accrual AS amount, number AS order_number, uploaded_at AS created_at
+	FROM orders
+	WHERE status = 'PROCESSED' AND accrual IS NOT NULL AND accrual <> 0
+	UNION ALL
+	SELECT user_id, 'WITHDRAWAL', -sum, order_number, processed_at
+	FROM withdrawals
+) AS movements;
+
+INSERT INTO ledger_entries (transaction_id, user_id, account, entry_type, amount, order_number, comment, created_at)
+SELECT transaction_id, user_id, 'user', entry_type, amount, order_number, 'backfill', COALESCE(created_at, NOW())
+FROM ledger_backfill
+UNION ALL
+SELECT transaction_id, user_id, 'system', entry_type, -amount, order_number, 'backfill', COALESCE(created_at, NOW())
+FROM ledger_backfill;
+
+DROP TABLE ledger_backfill;
+
+INSERT INTO user_balances (user_id, current, withdrawn)
+SELECT
+	u.id,
+	COALESCE(SUM(l.amount), 0),
+	COALESCE(-SUM(l.amount) FILTER (WHERE l.entry_type = 'WITHDRAWAL'), 0)
+FROM users u
+LEFT JOIN ledger_entries l ON l.user_id = u.id AND l.account = 'user'
+GROUP BY u.id;
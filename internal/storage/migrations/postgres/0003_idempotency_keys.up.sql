@@ -0,0 +1,14 @@
+CREATE TABLE idempotency_keys (
+	user_id INT NOT NULL REFERENCES users(id),
+	key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	-- 0, пока исходный запрос ещё обрабатывается
+	status_code INT NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL DEFAULT '',
+	body BYTEA,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idempotency_keys_expires_idx ON idempotency_keys (expires_at);
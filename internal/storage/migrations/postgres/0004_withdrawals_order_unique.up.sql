@@ -0,0 +1,36 @@
+-- до ограничения одно и то же списание могло пройти несколько раз. Ограничение общее для всех
+-- пользователей, поэтому дублем считается любое повторное списание по тому же номеру заказа,
+-- в том числе другим пользователем. Первое по времени остаётся, остальные удаляются, а их суммы
+-- возвращаются на баланс проводкой REFUND: журнал только дополняется, и списанное по нему
+-- сходится с балансом
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_entry_type_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_entry_type_check
+	CHECK (entry_type IN ('ACCRUAL', 'WITHDRAWAL', 'ADJUSTMENT', 'REVERSAL', 'REFUND'));
+
+CREATE TEMPORARY TABLE duplicate_withdrawals AS
+SELECT nextval('ledger_transaction_seq') AS transaction_id, id, user_id, order_number, sum
+FROM (
+	SELECT id, user_id, order_number, sum,
+		ROW_NUMBER() OVER (PARTITION BY order_number ORDER BY processed_at, id) AS n
+	FROM withdrawals
+) AS ranked
+WHERE n > 1;
+
+INSERT INTO ledger_entries (transaction_id, user_id, account, entry_type, amount, order_number, comment)
+SELECT transaction_id, user_id, 'user', 'REFUND', sum, order_number, 'duplicate withdrawal refund'
+FROM duplicate_withdrawals
+UNION ALL
+SELECT transaction_id, user_id, 'system', 'REFUND', -sum, order_number, 'duplicate withdrawal refund'
+FROM duplicate_withdrawals;
+
+UPDATE user_balances b
+SET current = b.current + d.total, withdrawn = b.withdrawn - d.total, updated_at = NOW()
+FROM (SELECT user_id, SUM(sum) AS total FROM duplicate_withdrawals GROUP BY user_id) d
+WHERE b.user_id = d.user_id;
+
+DELETE FROM withdrawals WHERE id IN (SELECT id FROM duplicate_withdrawals);
+
+DROP TABLE duplicate_withdrawals;
+
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_order_number_key;
+ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_order_number_key UNIQUE (order_number);
@@ -0,0 +1,4 @@
+ALTER TABLE orders ADD COLUMN claimed_by TEXT;
+ALTER TABLE orders ADD COLUMN claimed_until TIMESTAMPTZ;
+
+CREATE INDEX orders_unprocessed_idx ON orders (uploaded_at) WHERE status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS invalid_reason;
+ALTER TABLE orders DROP COLUMN IF EXISTS next_check_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS last_checked_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS attempts;
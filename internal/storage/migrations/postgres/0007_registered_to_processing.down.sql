@@ -0,0 +1,2 @@
+-- исходный статус не сохранялся, откатывать нечего
+SELECT 1;
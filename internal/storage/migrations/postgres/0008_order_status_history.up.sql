@@ -0,0 +1,19 @@
+CREATE TABLE order_status_history (
+	id BIGSERIAL PRIMARY KEY,
+	order_number TEXT NOT NULL REFERENCES orders(number),
+	status TEXT NOT NULL,
+	accrual NUMERIC,
+	reason TEXT,
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX order_status_history_order_idx ON order_status_history (order_number, id);
+
+-- для уже загруженных заказов известны только момент загрузки и текущий статус
+-- (время последней проверки — лучшее приближение к моменту смены статуса, как и в SQLite)
+INSERT INTO order_status_history (order_number, status, changed_at)
+SELECT number, 'NEW', COALESCE(uploaded_at, NOW()) FROM orders ORDER BY uploaded_at;
+
+INSERT INTO order_status_history (order_number, status, accrual, reason, changed_at)
+SELECT number, status, accrual, invalid_reason, COALESCE(last_checked_at, uploaded_at, NOW())
+FROM orders WHERE status <> 'NEW' ORDER BY uploaded_at;
@@ -0,0 +1,9 @@
+-- неудачные входы по логину ("login:<login>") и по адресу ("ip:<addr>"), общие для всех реплик
+CREATE TABLE login_attempts (
+	key TEXT PRIMARY KEY,
+	failures INT NOT NULL DEFAULT 0,
+	locked_until TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_attempts_updated_idx ON login_attempts (updated_at);
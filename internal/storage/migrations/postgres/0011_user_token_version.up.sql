@@ -0,0 +1,2 @@
+-- увеличивается при смене пароля; access-токены с прежней версией больше не принимаются
+ALTER TABLE users ADD COLUMN token_version INT NOT NULL DEFAULT 0;
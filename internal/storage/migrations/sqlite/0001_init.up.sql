@@ -0,0 +1,72 @@
+-- суммы баллов хранятся целым числом сотых долей
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE orders (
+	number TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	status TEXT NOT NULL DEFAULT 'NEW',
+	accrual INTEGER,
+	uploaded_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX orders_user_idx ON orders (user_id, uploaded_at);
+CREATE INDEX orders_status_idx ON orders (status, uploaded_at);
+
+CREATE TABLE withdrawals (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	order_number TEXT NOT NULL UNIQUE,
+	sum INTEGER NOT NULL,
+	processed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX withdrawals_user_idx ON withdrawals (user_id, processed_at);
+
+-- каждая проводка — две записи с одним transaction_id: по счёту пользователя и по системному счёту
+CREATE TABLE ledger_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	account TEXT NOT NULL CHECK (account IN ('user', 'system')),
+	entry_type TEXT NOT NULL CHECK (entry_type IN ('ACCRUAL', 'WITHDRAWAL', 'ADJUSTMENT', 'REVERSAL')),
+	amount INTEGER NOT NULL,
+	order_number TEXT,
+	comment TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX ledger_entries_user_idx ON ledger_entries (user_id, account, id);
+CREATE INDEX ledger_entries_transaction_idx ON ledger_entries (transaction_id);
+
+CREATE TRIGGER ledger_entries_no_update BEFORE UPDATE ON ledger_entries
+BEGIN
+	SELECT RAISE(ABORT, 'ledger_entries is append-only');
+END;
+
+CREATE TRIGGER ledger_entries_no_delete BEFORE DELETE ON ledger_entries
+BEGIN
+	SELECT RAISE(ABORT, 'ledger_entries is append-only');
+END;
+
+CREATE TABLE user_balances (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id),
+	current INTEGER NOT NULL DEFAULT 0,
+	withdrawn INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE idempotency_keys (
+	user_id INTEGER NOT NULL REFERENCES users(id),
+	key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL DEFAULT '',
+	body BLOB,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
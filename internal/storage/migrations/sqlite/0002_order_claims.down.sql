@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN claimed_until;
+ALTER TABLE orders DROP COLUMN claimed_by;
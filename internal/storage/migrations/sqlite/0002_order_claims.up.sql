@@ -0,0 +1,2 @@
+ALTER TABLE orders ADD COLUMN claimed_by TEXT;
+ALTER TABLE orders ADD COLUMN claimed_until TIMESTAMP;
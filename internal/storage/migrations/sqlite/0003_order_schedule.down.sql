@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN invalid_reason;
+ALTER TABLE orders DROP COLUMN next_check_at;
+ALTER TABLE orders DROP COLUMN last_checked_at;
+ALTER TABLE orders DROP COLUMN attempts;
@@ -0,0 +1,4 @@
+ALTER TABLE orders ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN last_checked_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN next_check_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN invalid_reason TEXT;
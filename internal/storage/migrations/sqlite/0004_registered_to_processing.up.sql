@@ -0,0 +1,2 @@
+-- REGISTERED — статус системы начислений; у заказов он означает, что расчёт ещё идёт
+UPDATE orders SET status = 'PROCESSING' WHERE status = 'REGISTERED';
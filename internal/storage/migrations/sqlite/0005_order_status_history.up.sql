@@ -0,0 +1,18 @@
+CREATE TABLE order_status_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	order_number TEXT NOT NULL REFERENCES orders(number),
+	status TEXT NOT NULL,
+	accrual INTEGER,
+	reason TEXT,
+	changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX order_status_history_order_idx ON order_status_history (order_number, id);
+
+-- для уже загруженных заказов известны только момент загрузки и текущий статус
+INSERT INTO order_status_history (order_number, status, changed_at)
+SELECT number, 'NEW', uploaded_at FROM orders ORDER BY uploaded_at;
+
+INSERT INTO order_status_history (order_number, status, accrual, reason, changed_at)
+SELECT number, status, accrual, invalid_reason, COALESCE(last_checked_at, uploaded_at)
+FROM orders WHERE status <> 'NEW' ORDER BY uploaded_at;
@@ -0,0 +1,9 @@
+-- неудачные входы по логину ("login:<login>") и по адресу ("ip:<addr>")
+CREATE TABLE login_attempts (
+	key TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX login_attempts_updated_idx ON login_attempts (updated_at);
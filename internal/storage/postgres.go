@@ -2,10 +2,15 @@ package storage
 
 import (
 	"context"
+	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
+	"sort"
+	"time"
 
 	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/migrate"
 	"github.com/and161185/loyalty/internal/model"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -16,34 +21,31 @@ type PostgresStorage struct {
 	db *pgxpool.Pool
 }
 
-func (s *PostgresStorage) initSchema(ctx context.Context) error {
-	const initSchemaQuery = `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		login TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW()
-	);
-	CREATE TABLE IF NOT EXISTS orders (
-		number TEXT PRIMARY KEY,
-		user_id INT NOT NULL REFERENCES users(id),
-		status TEXT NOT NULL DEFAULT 'NEW',
-		accrual NUMERIC,
-		uploaded_at TIMESTAMP DEFAULT NOW()
-	);
-	CREATE TABLE IF NOT EXISTS withdrawals (
-		id SERIAL PRIMARY KEY,
-		user_id INT NOT NULL REFERENCES users(id),
-		order_number TEXT NOT NULL,
-		sum NUMERIC NOT NULL,
-		processed_at TIMESTAMP DEFAULT NOW()
-	);`
-
-	_, err := s.db.Exec(ctx, initSchemaQuery)
-	return err
-}
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
 
 func NewPostgreStorage(ctx context.Context, DatabaseURI string) (*PostgresStorage, error) {
+	storage, err := ConnectPostgres(ctx, DatabaseURI)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := storage.Migrator()
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return storage, nil
+}
+
+// ConnectPostgres подключается к базе без применения миграций
+func ConnectPostgres(ctx context.Context, DatabaseURI string) (*PostgresStorage, error) {
 	db, err := pgxpool.New(ctx, DatabaseURI)
 	if err != nil {
 		return nil, err
@@ -52,14 +54,24 @@ func NewPostgreStorage(ctx context.Context, DatabaseURI string) (*PostgresStorag
 	storage := &PostgresStorage{db: db}
 
 	if err := storage.Ping(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	if err := storage.initSchema(ctx); err != nil {
+	return storage, nil
+}
+
+func (s *PostgresStorage) Migrator() (*migrate.Migrator, error) {
+	migrations, err := fs.Sub(postgresMigrations, "migrations/postgres")
+	if err != nil {
 		return nil, err
 	}
 
-	return storage, nil
+	return migrate.NewMigrator(s.db, migrations)
+}
+
+func (s *PostgresStorage) Close() {
+	s.db.Close()
 }
 
 func (s *PostgresStorage) Ping(ctx context.Context) error {
@@ -82,12 +94,12 @@ func (s *PostgresStorage) CreateUser(ctx context.Context, login string, password
 }
 
 func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (model.User, string, error) {
-	const query = `SELECT id, login, password_hash FROM users WHERE login = $1`
+	const query = `SELECT id, login, token_version, password_hash FROM users WHERE login = $1`
 
 	var user model.User
 	var hash string
 
-	err := s.db.QueryRow(ctx, query, login).Scan(&user.ID, &user.Login, &hash)
+	err := s.db.QueryRow(ctx, query, login).Scan(&user.ID, &user.Login, &user.TokenVersion, &hash)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return model.User{}, "", errs.ErrUserNotFound
@@ -99,11 +111,11 @@ func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (mod
 }
 
 func (s *PostgresStorage) GetUserByID(ctx context.Context, id int) (model.User, error) {
-	const query = `SELECT id, login FROM users WHERE id = $1`
+	const query = `SELECT id, login, token_version FROM users WHERE id = $1`
 
 	var user model.User
 
-	err := s.db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Login)
+	err := s.db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Login, &user.TokenVersion)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return model.User{}, errs.ErrUserNotFound
@@ -114,11 +126,31 @@ func (s *PostgresStorage) GetUserByID(ctx context.Context, id int) (model.User,
 	return user, nil
 }
 
+func (s *PostgresStorage) UpdatePassword(ctx context.Context, userID int, passwordHash string) error {
+	const query = `UPDATE users SET password_hash = $2, token_version = token_version + 1 WHERE id = $1`
+
+	tag, err := s.db.Exec(ctx, query, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errs.ErrUserNotFound
+	}
+
+	return nil
+}
+
 func (s *PostgresStorage) AddOrder(ctx context.Context, user model.User, order model.Order) (int, error) {
+	// история заказа начинается с записи NEW в том же запросе, что и сам заказ
 	const query = `
-		INSERT INTO orders (number, user_id)
-		VALUES ($1, $2)
-		ON CONFLICT (number) DO NOTHING`
+		WITH inserted AS (
+			INSERT INTO orders (number, user_id)
+			VALUES ($1, $2)
+			ON CONFLICT (number) DO NOTHING
+			RETURNING number, status, uploaded_at
+		)
+		INSERT INTO order_status_history (order_number, status, changed_at)
+		SELECT number, status, uploaded_at FROM inserted`
 
 	const checkOrderOwnerQuery = `SELECT user_id, status FROM orders WHERE number = $1`
 
@@ -150,15 +182,17 @@ func (s *PostgresStorage) AddOrder(ctx context.Context, user model.User, order m
 	return 202, nil // Новый заказ принят
 }
 
+// GetUserOrders — заказы пользователя для списка. У отменённых (REVERSED) начисление не отдаётся:
+// по спецификации сумма есть только у PROCESSED, а отменённые баллы пользователю уже не принадлежат
 func (s *PostgresStorage) GetUserOrders(ctx context.Context, user model.User) ([]model.Order, error) {
 	const query = `
-		SELECT number, status, accrual, uploaded_at
+		SELECT number, status, CASE WHEN status = $2 THEN NULL ELSE accrual END, uploaded_at
 		FROM orders
 		WHERE user_id = $1
 		ORDER BY uploaded_at DESC
 	`
 
-	rows, err := s.db.Query(ctx, query, user.ID)
+	rows, err := s.db.Query(ctx, query, user.ID, model.Reversed)
 	if err != nil {
 		return nil, fmt.Errorf("get user orders: %w", err)
 	}
@@ -185,36 +219,142 @@ func (s *PostgresStorage) GetUserOrders(ctx context.Context, user model.User) ([
 	return orders, nil
 }
 
-func (s *PostgresStorage) GetUserBalance(ctx context.Context, user model.User) (model.Balance, error) {
+// ReverseOrder отменяет начисление по обработанному заказу: заказ становится REVERSED, а начисленные
+// баллы списываются проводкой REVERSAL. Если баллы уже потрачены, баланс уходит в минус и гасится
+// следующими начислениями. Повторная отмена ничего не меняет
+func (s *PostgresStorage) ReverseOrder(ctx context.Context, number string, reason string) error {
 	const query = `
-		SELECT 
-			COALESCE(SUM(accrual), 0) AS total_accrual,
-			(SELECT COALESCE(SUM(sum), 0) FROM withdrawals WHERE user_id = $1) AS total_withdrawn
+		UPDATE orders
+		SET status = $1, invalid_reason = NULLIF($3, '')
+		WHERE number = $2 AND status = $4
+		RETURNING user_id, accrual`
+
+	const currentStatusQuery = `SELECT status FROM orders WHERE number = $1`
+
+	const insertHistoryQuery = `
+		INSERT INTO order_status_history (order_number, status, accrual, reason)
+		VALUES ($1, $2, $3, NULLIF($4, ''))`
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	var accrual *model.Points
+	err = tx.QueryRow(ctx, query, model.Reversed, number, reason, model.Processed).Scan(&userID, &accrual)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var current model.OrderStatus
+		err = tx.QueryRow(ctx, currentStatusQuery, number).Scan(&current)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errs.ErrOrderNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("select order status: %w", err)
+		}
+		return rejectedTransition(current, model.Reversed)
+	}
+	if err != nil {
+		return fmt.Errorf("reverse order: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, insertHistoryQuery, number, model.Reversed, accrual, reason)
+	if err != nil {
+		return fmt.Errorf("insert status history: %w", err)
+	}
+
+	if accrual != nil && *accrual != 0 {
+		err = postLedgerEntry(ctx, tx, model.LedgerEntry{
+			UserID:      userID,
+			Type:        model.LedgerReversal,
+			Amount:      -*accrual,
+			OrderNumber: number,
+			Comment:     reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetUserOrder(ctx context.Context, user model.User, number string) (model.OrderDetails, error) {
+	const orderQuery = `
+		SELECT number, status, accrual, uploaded_at
 		FROM orders
-		WHERE user_id = $1 AND status = 'PROCESSED'
-	`
+		WHERE number = $1 AND user_id = $2`
+
+	const historyQuery = `
+		SELECT status, accrual, COALESCE(reason, ''), changed_at
+		FROM order_status_history
+		WHERE order_number = $1
+		ORDER BY id`
 
-	var accrual, withdrawn float64
-	err := s.db.QueryRow(ctx, query, user.ID).Scan(&accrual, &withdrawn)
+	var details model.OrderDetails
+	o := &details.Order
+	err := s.db.QueryRow(ctx, orderQuery, number, user.ID).Scan(&o.Number, &o.Status, &o.Accrual, &o.UploadedAt)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.OrderDetails{}, errs.ErrOrderNotFound
+		}
+		return model.OrderDetails{}, fmt.Errorf("get user order: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, historyQuery, number)
+	if err != nil {
+		return model.OrderDetails{}, fmt.Errorf("get order history: %w", err)
+	}
+	defer rows.Close()
+
+	details.History = []model.OrderStatusChange{}
+	for rows.Next() {
+		var change model.OrderStatusChange
+		err := rows.Scan(&change.Status, &change.Accrual, &change.Reason, &change.ChangedAt)
+		if err != nil {
+			return model.OrderDetails{}, fmt.Errorf("scan order history: %w", err)
+		}
+		details.History = append(details.History, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return model.OrderDetails{}, fmt.Errorf("row iteration: %w", err)
+	}
+
+	return details, nil
+}
+
+func (s *PostgresStorage) GetUserBalance(ctx context.Context, user model.User) (model.Balance, error) {
+	const query = `SELECT current, withdrawn FROM user_balances WHERE user_id = $1`
+
+	var balance model.Balance
+	err := s.db.QueryRow(ctx, query, user.ID).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Balance{}, nil // движений по счёту ещё не было
+		}
 		return model.Balance{}, fmt.Errorf("get balance: %w", err)
 	}
 
-	return model.Balance{
-		Current:   accrual - withdrawn,
-		Withdrawn: withdrawn,
-	}, nil
+	return balance, nil
 }
 
-func (s *PostgresStorage) WithdrawBalance(ctx context.Context, user model.User, order string, sum float64) error {
-	const checkBalanceQuery = `
-		SELECT 
-			COALESCE(SUM(accrual), 0) - 
-			(SELECT COALESCE(SUM(sum), 0) FROM withdrawals WHERE user_id = $1)
-		FROM orders
-		WHERE user_id = $1 AND status = 'PROCESSED'
+func (s *PostgresStorage) WithdrawBalance(ctx context.Context, user model.User, order string, sum model.Points) error {
+	const ensureBalanceQuery = `
+		INSERT INTO user_balances (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO NOTHING
 	`
 
+	// строка баланса блокируется до конца транзакции, параллельные списания встают в очередь
+	const checkBalanceQuery = `SELECT current FROM user_balances WHERE user_id = $1 FOR UPDATE`
+
+	const checkWithdrawalQuery = `SELECT EXISTS (SELECT 1 FROM withdrawals WHERE order_number = $1)`
+
 	const insertWithdrawalQuery = `
 		INSERT INTO withdrawals (user_id, order_number, sum)
 		VALUES ($1, $2, $3)
@@ -226,21 +366,50 @@ func (s *PostgresStorage) WithdrawBalance(ctx context.Context, user model.User,
 	}
 	defer tx.Rollback(ctx)
 
-	var balance float64
+	_, err = tx.Exec(ctx, ensureBalanceQuery, user.ID)
+	if err != nil {
+		return fmt.Errorf("ensure balance: %w", err)
+	}
+
+	var balance model.Points
 	err = tx.QueryRow(ctx, checkBalanceQuery, user.ID).Scan(&balance)
 	if err != nil {
 		return fmt.Errorf("check balance: %w", err)
 	}
 
+	// повтор уже проведённого списания важнее нехватки баллов
+	var exists bool
+	err = tx.QueryRow(ctx, checkWithdrawalQuery, order).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check withdrawal: %w", err)
+	}
+	if exists {
+		return errs.ErrWithdrawalExists
+	}
+
 	if balance < sum {
 		return errs.ErrInsufficientFunds
 	}
 
 	_, err = tx.Exec(ctx, insertWithdrawalQuery, user.ID, order, sum)
 	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			// параллельное списание по тому же заказу от другого пользователя
+			return errs.ErrWithdrawalExists
+		}
 		return fmt.Errorf("insert withdrawal: %w", err)
 	}
 
+	err = postLedgerEntry(ctx, tx, model.LedgerEntry{
+		UserID:      user.ID,
+		Type:        model.LedgerWithdrawal,
+		Amount:      -sum,
+		OrderNumber: order,
+	})
+	if err != nil {
+		return err
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("commit: %w", err)
@@ -284,11 +453,111 @@ func (s *PostgresStorage) GetWithdrawals(ctx context.Context, user model.User) (
 	return list, nil
 }
 
+func (s *PostgresStorage) GetWithdrawal(ctx context.Context, user model.User, order string) (model.Withdrawal, error) {
+	const query = `
+		SELECT order_number, sum, processed_at
+		FROM withdrawals
+		WHERE user_id = $1 AND order_number = $2
+	`
+
+	var w model.Withdrawal
+	err := s.db.QueryRow(ctx, query, user.ID, order).Scan(&w.Order, &w.Sum, &w.ProcessedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Withdrawal{}, errs.ErrWithdrawalNotFound
+		}
+		return model.Withdrawal{}, fmt.Errorf("get withdrawal: %w", err)
+	}
+
+	return w, nil
+}
+
+// ClaimUnprocessedOrders берёт в аренду до limit заказов, которые не обработаны
+// и не заняты другим экземпляром; строки, заблокированные параллельными claim, пропускаются
+func (s *PostgresStorage) ClaimUnprocessedOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]model.Order, error) {
+	const query = `
+		WITH claimable AS (
+			SELECT number
+			FROM orders
+			WHERE status IN ('NEW', 'PROCESSING')
+				AND (next_check_at IS NULL OR next_check_at <= NOW())
+				AND (claimed_until IS NULL OR claimed_until < NOW())
+			ORDER BY uploaded_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE orders o
+		SET claimed_by = $1, claimed_until = NOW() + $3 * INTERVAL '1 millisecond'
+		FROM claimable c
+		WHERE o.number = c.number
+		RETURNING o.number, o.status, o.uploaded_at, o.attempts
+	`
+
+	rows, err := s.db.Query(ctx, query, owner, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim unprocessed orders: %w", err)
+	}
+	defer rows.Close()
+
+	var list []model.Order
+	for rows.Next() {
+		var o model.Order
+		if err := rows.Scan(&o.Number, &o.Status, &o.UploadedAt, &o.Attempts); err != nil {
+			return nil, fmt.Errorf("scan claimed order: %w", err)
+		}
+		list = append(list, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	// RETURNING не сохраняет порядок CTE
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UploadedAt.Before(list[j].UploadedAt)
+	})
+
+	return list, nil
+}
+
+// ScheduleNextCheck фиксирует очередную безрезультатную проверку заказа и откладывает следующую до nextCheckAt
+func (s *PostgresStorage) ScheduleNextCheck(ctx context.Context, number string, nextCheckAt time.Time) error {
+	const query = `
+		UPDATE orders
+		SET attempts = attempts + 1, last_checked_at = NOW(), next_check_at = $2
+		WHERE number = $1
+	`
+
+	_, err := s.db.Exec(ctx, query, number, nextCheckAt)
+	if err != nil {
+		return fmt.Errorf("schedule next check: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseOrder снимает аренду, если она всё ещё принадлежит owner
+func (s *PostgresStorage) ReleaseOrder(ctx context.Context, owner string, number string) error {
+	const query = `
+		UPDATE orders
+		SET claimed_by = NULL, claimed_until = NULL
+		WHERE number = $1 AND claimed_by = $2
+	`
+
+	_, err := s.db.Exec(ctx, query, number, owner)
+	if err != nil {
+		return fmt.Errorf("release order: %w", err)
+	}
+
+	return nil
+}
+
 func (s *PostgresStorage) GetUnprocessedOrders(ctx context.Context) ([]model.Order, error) {
 	const query = `
 		SELECT number
 		FROM orders	
 		WHERE status IN ('NEW', 'PROCESSING')
+			AND (next_check_at IS NULL OR next_check_at <= NOW())
 		ORDER BY uploaded_at ASC
 	`
 
@@ -316,19 +585,67 @@ func (s *PostgresStorage) GetUnprocessedOrders(ctx context.Context) ([]model.Ord
 }
 
 func (s *PostgresStorage) UpdateOrder(ctx context.Context, order model.Order) error {
+	// статус меняется, только если переход допустим; так обработанный заказ не откатится назад
+	// и его начисление не перезапишется
 	const query = `
 		UPDATE orders 
-		SET status = $1, accrual = $2
-		WHERE number = $3`
+		SET status = $1, accrual = $2, invalid_reason = NULLIF($4, '')
+		WHERE number = $3 AND status = ANY($5)
+		RETURNING user_id`
+
+	const currentStatusQuery = `SELECT status FROM orders WHERE number = $1`
+
+	const insertHistoryQuery = `
+		INSERT INTO order_status_history (order_number, status, accrual, reason)
+		VALUES ($1, $2, $3, NULLIF($4, ''))`
 
 	status := order.Status
 	accrual := order.Accrual
 	number := order.Number
 
-	_, err := s.db.Exec(ctx, query, status, accrual, number)
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx, query, status, accrual, number, order.InvalidReason, previousStatuses(status)).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var current model.OrderStatus
+		err = tx.QueryRow(ctx, currentStatusQuery, number).Scan(&current)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errs.ErrOrderNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("select order status: %w", err)
+		}
+		return rejectedTransition(current, status)
+	}
 	if err != nil {
 		return fmt.Errorf("update order status: %w", err)
 	}
 
+	_, err = tx.Exec(ctx, insertHistoryQuery, number, status, accrual, order.InvalidReason)
+	if err != nil {
+		return fmt.Errorf("insert status history: %w", err)
+	}
+
+	if status == model.Processed && accrual != nil && *accrual != 0 {
+		err = postLedgerEntry(ctx, tx, model.LedgerEntry{
+			UserID:      userID,
+			Type:        model.LedgerAccrual,
+			Amount:      *accrual,
+			OrderNumber: number,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
 	return nil
 }
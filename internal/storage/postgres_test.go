@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/migrate"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/storage/storagetest"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPostgres подключается к базе из TEST_DATABASE_URI и очищает её;
+// без переменной окружения тест пропускается
+func newTestPostgres(t *testing.T) *PostgresStorage {
+	t.Helper()
+
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	ctx := context.Background()
+	s, err := NewPostgreStorage(ctx, uri)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	_, err = s.db.Exec(ctx, `TRUNCATE users RESTART IDENTITY CASCADE`)
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestPostgresConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return newTestPostgres(t)
+	})
+}
+
+func createTestUser(t *testing.T, s *PostgresStorage, login string) model.User {
+	t.Helper()
+
+	ctx := context.Background()
+	require.NoError(t, s.CreateUser(ctx, login, "hash"))
+
+	user, _, err := s.GetUserByLogin(ctx, login)
+	require.NoError(t, err)
+
+	return user
+}
+
+func TestPostgresLedger(t *testing.T) {
+	s := newTestPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, s, "ledger")
+
+	_, err := s.AddOrder(ctx, user, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+
+	accrual := model.Points(10050)
+	processed := model.Order{Number: "12345678903", Status: model.Processed, Accrual: &accrual}
+	require.NoError(t, s.UpdateOrder(ctx, processed))
+	// повторное обновление не должно начислить баллы второй раз
+	require.NoError(t, s.UpdateOrder(ctx, processed))
+
+	require.NoError(t, s.WithdrawBalance(ctx, user, "2377225624", 30*model.Point))
+	require.NoError(t, s.AdjustBalance(ctx, user, -50, "correction"))
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 70 * model.Point, Withdrawn: 30 * model.Point}, balance)
+
+	entries, err := s.GetLedgerEntries(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, model.LedgerAccrual, entries[0].Type)
+	require.Equal(t, model.LedgerWithdrawal, entries[1].Type)
+	require.Equal(t, -30*model.Point, entries[1].Amount)
+	require.Equal(t, model.LedgerAdjustment, entries[2].Type)
+
+	mismatched, err := s.ReconcileBalances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mismatched)
+
+	_, err = s.db.Exec(ctx, `DELETE FROM ledger_entries`)
+	require.Error(t, err, "ledger must be append-only")
+}
+
+func TestPostgresConcurrentWithdrawals(t *testing.T) {
+	s := newTestPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, s, "concurrent")
+
+	require.NoError(t, s.AdjustBalance(ctx, user, 100*model.Point, "initial"))
+
+	const attempts = 50
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	errCh := make(chan error, attempts)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.WithdrawBalance(ctx, user, fmt.Sprintf("order-%d", i), 10*model.Point)
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, errs.ErrInsufficientFunds):
+				errCh <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int32(10), succeeded.Load())
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0, Withdrawn: 100 * model.Point}, balance)
+}
+
+func TestPostgresDuplicateWithdrawal(t *testing.T) {
+	s := newTestPostgres(t)
+	ctx := context.Background()
+	user := createTestUser(t, s, "duplicate")
+	other := createTestUser(t, s, "other")
+
+	require.NoError(t, s.AdjustBalance(ctx, user, 100*model.Point, "initial"))
+	require.NoError(t, s.AdjustBalance(ctx, other, 100*model.Point, "initial"))
+
+	require.NoError(t, s.WithdrawBalance(ctx, user, "2377225624", 60*model.Point))
+	// повтор важнее нехватки баллов
+	require.ErrorIs(t, s.WithdrawBalance(ctx, user, "2377225624", 60*model.Point), errs.ErrWithdrawalExists)
+	require.ErrorIs(t, s.WithdrawBalance(ctx, other, "2377225624", 10*model.Point), errs.ErrWithdrawalExists)
+
+	w, err := s.GetWithdrawal(ctx, user, "2377225624")
+	require.NoError(t, err)
+	require.Equal(t, 60*model.Point, w.Sum)
+
+	_, err = s.GetWithdrawal(ctx, other, "2377225624")
+	require.ErrorIs(t, err, errs.ErrWithdrawalNotFound)
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, 40*model.Point, balance.Current)
+}
+
+// migrationsUpTo — встроенные миграции Postgres до версии last включительно
+func migrationsUpTo(t *testing.T, last int) fstest.MapFS {
+	t.Helper()
+
+	all, err := fs.Sub(postgresMigrations, "migrations/postgres")
+	require.NoError(t, err)
+
+	files, err := fs.ReadDir(all, ".")
+	require.NoError(t, err)
+
+	subset := fstest.MapFS{}
+	for _, f := range files {
+		version, err := strconv.Atoi(strings.SplitN(f.Name(), "_", 2)[0])
+		require.NoError(t, err)
+		if version > last {
+			continue
+		}
+		body, err := fs.ReadFile(all, f.Name())
+		require.NoError(t, err)
+		subset[f.Name()] = &fstest.MapFile{Data: body}
+	}
+
+	return subset
+}
+
+// newTestSchema открывает пул, работающий в отдельной пустой схеме, чтобы миграции
+// можно было прогнать с нуля рядом с основной тестовой базой
+func newTestSchema(t *testing.T, schema string) *pgxpool.Pool {
+	t.Helper()
+
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	ctx := context.Background()
+	admin, err := pgxpool.New(ctx, uri)
+	require.NoError(t, err)
+	t.Cleanup(admin.Close)
+
+	_, err = admin.Exec(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE`)
+	require.NoError(t, err)
+	_, err = admin.Exec(ctx, `CREATE SCHEMA `+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = admin.Exec(context.Background(), `DROP SCHEMA IF EXISTS `+schema+` CASCADE`)
+	})
+
+	cfg, err := pgxpool.ParseConfig(uri)
+	require.NoError(t, err)
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+
+	db, err := pgxpool.NewWithConfig(ctx, cfg)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	return db
+}
+
+func TestPostgresMigrateDuplicateWithdrawals(t *testing.T) {
+	db := newTestSchema(t, "migrate_duplicate_withdrawals")
+	ctx := context.Background()
+
+	// база в том виде, в каком она была до журнала и ограничения на списания
+	migrator, err := migrate.NewMigrator(db, migrationsUpTo(t, 1))
+	require.NoError(t, err)
+	require.NoError(t, migrator.Up(ctx))
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO users (id, login, password_hash) VALUES (1, 'alice', 'hash'), (2, 'bob', 'hash');
+		INSERT INTO orders (number, user_id, status, accrual) VALUES ('12345678903', 1, 'PROCESSED', 100);
+		INSERT INTO withdrawals (user_id, order_number, sum, processed_at) VALUES
+			(1, '2377225624', 30, NOW() - INTERVAL '3 hours'),
+			(1, '2377225624', 30, NOW() - INTERVAL '2 hours'),
+			(2, '2377225624', 20, NOW() - INTERVAL '1 hour'),
+			(1, '79927398713', 10, NOW());
+	`)
+	require.NoError(t, err)
+
+	s := &PostgresStorage{db: db}
+	migrator, err = s.Migrator()
+	require.NoError(t, err)
+	require.NoError(t, migrator.Up(ctx))
+
+	alice := model.User{ID: 1, Login: "alice"}
+	bob := model.User{ID: 2, Login: "bob"}
+
+	w, err := s.GetWithdrawal(ctx, alice, "2377225624")
+	require.NoError(t, err)
+	require.Equal(t, 30*model.Point, w.Sum)
+
+	_, err = s.GetWithdrawal(ctx, bob, "2377225624")
+	require.ErrorIs(t, err, errs.ErrWithdrawalNotFound)
+
+	// повторные списания вернулись на баланс
+	balance, err := s.GetUserBalance(ctx, alice)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 60 * model.Point, Withdrawn: 40 * model.Point}, balance)
+
+	balance, err = s.GetUserBalance(ctx, bob)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{}, balance)
+
+	mismatched, err := s.ReconcileBalances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, mismatched)
+
+	// возврат виден в журнале отдельным типом, а не списанием с обратным знаком
+	entries, err := s.GetLedgerEntries(ctx, bob)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, model.LedgerWithdrawal, entries[0].Type)
+	require.Equal(t, model.LedgerRefund, entries[1].Type)
+	require.Equal(t, 20*model.Point, entries[1].Amount)
+
+	require.ErrorIs(t, s.WithdrawBalance(ctx, alice, "2377225624", 10*model.Point), errs.ErrWithdrawalExists)
+}
+
+func TestPostgresMigrateOrderHistoryBackfill(t *testing.T) {
+	db := newTestSchema(t, "migrate_order_history")
+	ctx := context.Background()
+
+	migrator, err := migrate.NewMigrator(db, migrationsUpTo(t, 7))
+	require.NoError(t, err)
+	require.NoError(t, migrator.Up(ctx))
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO users (id, login, password_hash) VALUES (1, 'alice', 'hash');
+		INSERT INTO orders (number, user_id, status, accrual, uploaded_at, last_checked_at) VALUES
+			('12345678903', 1, 'PROCESSED', 100, NOW() - INTERVAL '3 days', NOW() - INTERVAL '2 days'),
+			('79927398713', 1, 'NEW', NULL, NOW() - INTERVAL '1 day', NULL);
+	`)
+	require.NoError(t, err)
+
+	s := &PostgresStorage{db: db}
+	migrator, err = s.Migrator()
+	require.NoError(t, err)
+	require.NoError(t, migrator.Up(ctx))
+
+	alice := model.User{ID: 1, Login: "alice"}
+
+	// смена статуса датируется последней проверкой, а не моментом миграции
+	details, err := s.GetUserOrder(ctx, alice, "12345678903")
+	require.NoError(t, err)
+	require.Len(t, details.History, 2)
+	require.Equal(t, model.New, details.History[0].Status)
+	require.WithinDuration(t, time.Now().Add(-72*time.Hour), details.History[0].ChangedAt, time.Minute)
+	require.Equal(t, model.Processed, details.History[1].Status)
+	require.WithinDuration(t, time.Now().Add(-48*time.Hour), details.History[1].ChangedAt, time.Minute)
+
+	details, err = s.GetUserOrder(ctx, alice, "79927398713")
+	require.NoError(t, err)
+	require.Len(t, details.History, 1)
+	require.WithinDuration(t, time.Now().Add(-24*time.Hour), details.History[0].ChangedAt, time.Minute)
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStorage) CreateRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	const query = `
+		INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := s.db.Exec(ctx, query, token.Hash, token.UserID, token.FamilyID, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken гасит предъявленный refresh-токен и сохраняет вместо него next из того же семейства.
+// Повторное предъявление уже погашенного токена значит, что его кто-то перехватил: тогда отзывается
+// всё семейство и возвращается ErrRefreshTokenReused
+func (s *PostgresStorage) RotateRefreshToken(ctx context.Context, hash string, next model.RefreshToken) (int, error) {
+	const selectQuery = `
+		SELECT user_id, family_id, expires_at, revoked_at IS NOT NULL
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+
+	const revokeFamilyQuery = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+
+	const revokeQuery = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE token_hash = $1`
+
+	const insertQuery = `
+		INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var expiresAt time.Time
+	var revoked bool
+	err = tx.QueryRow(ctx, selectQuery, hash).Scan(&next.UserID, &next.FamilyID, &expiresAt, &revoked)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, errs.ErrInvalidToken
+		}
+		return 0, fmt.Errorf("select refresh token: %w", err)
+	}
+
+	if revoked {
+		if _, err := tx.Exec(ctx, revokeFamilyQuery, next.FamilyID); err != nil {
+			return 0, fmt.Errorf("revoke refresh token family: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, fmt.Errorf("commit: %w", err)
+		}
+		return 0, errs.ErrRefreshTokenReused
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return 0, errs.ErrInvalidToken
+	}
+
+	if _, err := tx.Exec(ctx, revokeQuery, hash); err != nil {
+		return 0, fmt.Errorf("revoke refresh token: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, insertQuery, next.Hash, next.UserID, next.FamilyID, next.ExpiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+
+	return next.UserID, nil
+}
+
+// RevokeRefreshToken отзывает семейство, к которому относится токен пользователя; чужой или неизвестный токен игнорируется
+func (s *PostgresStorage) RevokeRefreshToken(ctx context.Context, userID int, hash string) error {
+	const query = `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2)
+			AND revoked_at IS NULL
+	`
+
+	_, err := s.db.Exec(ctx, query, hash, userID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserSessions отзывает все refresh-токены пользователя, например после смены пароля
+func (s *PostgresStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	const query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	if _, err := s.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("revoke user sessions: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeToken запоминает jti access-токена до его истечения; заодно удаляет записи, которые уже не нужны
+func (s *PostgresStorage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const deleteExpiredQuery = `DELETE FROM revoked_tokens WHERE expires_at < NOW()`
+
+	const insertQuery = `
+		INSERT INTO revoked_tokens (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`
+
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, deleteExpiredQuery); err != nil {
+			return fmt.Errorf("delete expired revoked tokens: %w", err)
+		}
+		if _, err := tx.Exec(ctx, insertQuery, jti, expiresAt); err != nil {
+			return fmt.Errorf("insert revoked token: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStorage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const query = `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	var revoked bool
+	if err := s.db.QueryRow(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("check revoked token: %w", err)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,1050 @@
+//go:build sqlite
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/migrate"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/mattn/go-sqlite3"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// SQLiteStorage — файловое хранилище для однонодовых установок. Суммы баллов хранятся
+// целым числом сотых, время — в UTC.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStorage(ctx context.Context, path string) (*SQLiteStorage, error) {
+	storage, err := ConnectSQLite(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := storage.Migrator()
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	if err := migrator.Up(ctx); err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return storage, nil
+}
+
+// ConnectSQLite открывает файл базы без применения миграций
+func ConnectSQLite(ctx context.Context, path string) (*SQLiteStorage, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite: empty database path")
+	}
+
+	// _txlock=immediate: транзакция сразу берёт блокировку на запись,
+	// так что проверка баланса и списание не пересекаются с другими записями
+	dsn := "file:" + path + "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &SQLiteStorage{db: db}
+
+	if err := storage.Ping(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+func (s *SQLiteStorage) Migrator() (*migrate.Migrator, error) {
+	migrations, err := fs.Sub(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewSQLiteMigrator(s.db, migrations)
+}
+
+func (s *SQLiteStorage) Close() {
+	s.db.Close()
+}
+
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+func (s *SQLiteStorage) CreateUser(ctx context.Context, login string, passwordHash string) error {
+	const insertUserQuery = `INSERT INTO users (login, password_hash, created_at) VALUES (?, ?, ?)`
+
+	_, err := s.db.ExecContext(ctx, insertUserQuery, login, passwordHash, time.Now().UTC())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return errs.ErrLoginAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetUserByLogin(ctx context.Context, login string) (model.User, string, error) {
+	const query = `SELECT id, login, token_version, password_hash FROM users WHERE login = ?`
+
+	var user model.User
+	var hash string
+
+	err := s.db.QueryRowContext(ctx, query, login).Scan(&user.ID, &user.Login, &user.TokenVersion, &hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, "", errs.ErrUserNotFound
+		}
+		return model.User{}, "", fmt.Errorf("get user by login: %w", err)
+	}
+
+	return user, hash, nil
+}
+
+func (s *SQLiteStorage) GetUserByID(ctx context.Context, id int) (model.User, error) {
+	const query = `SELECT id, login, token_version FROM users WHERE id = ?`
+
+	var user model.User
+
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Login, &user.TokenVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, errs.ErrUserNotFound
+		}
+		return model.User{}, fmt.Errorf("get user by id: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *SQLiteStorage) UpdatePassword(ctx context.Context, userID int, passwordHash string) error {
+	const query = `UPDATE users SET password_hash = ?, token_version = token_version + 1 WHERE id = ?`
+
+	res, err := s.db.ExecContext(ctx, query, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if n == 0 {
+		return errs.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) AddOrder(ctx context.Context, user model.User, order model.Order) (int, error) {
+	const query = `
+		INSERT INTO orders (number, user_id, uploaded_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (number) DO NOTHING`
+
+	const insertHistoryQuery = `
+		INSERT INTO order_status_history (order_number, status, changed_at)
+		VALUES (?, ?, ?)`
+
+	const checkOrderOwnerQuery = `SELECT user_id, status FROM orders WHERE number = ?`
+
+	var inserted int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		res, err := tx.ExecContext(ctx, query, order.Number, user.ID, now)
+		if err != nil {
+			return fmt.Errorf("insert order: %w", err)
+		}
+
+		inserted, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("insert order: %w", err)
+		}
+		if inserted == 0 {
+			return nil
+		}
+
+		// история заказа начинается с записи NEW в той же транзакции, что и сам заказ
+		_, err = tx.ExecContext(ctx, insertHistoryQuery, order.Number, model.New, now)
+		if err != nil {
+			return fmt.Errorf("insert status history: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Если вставка не произошла — заказ уже есть, надо выяснить чей
+	if inserted == 0 {
+		var existingUserID int
+		var existingStatus string
+		err = s.db.QueryRowContext(ctx, checkOrderOwnerQuery, order.Number).Scan(&existingUserID, &existingStatus)
+		if err != nil {
+			return 0, fmt.Errorf("select existing order: %w", err)
+		}
+		if existingUserID == user.ID {
+			if existingStatus == string(model.Processing) || existingStatus == string(model.Registered) {
+				return 202, nil // Уже загружен этим пользователем
+			}
+			return 200, nil
+		}
+		return 409, nil // Загружен другим
+	}
+
+	return 202, nil // Новый заказ принят
+}
+
+func (s *SQLiteStorage) GetUserOrders(ctx context.Context, user model.User) ([]model.Order, error) {
+	const query = `
+		SELECT number, status, CASE WHEN status = ? THEN NULL ELSE accrual END, uploaded_at
+		FROM orders
+		WHERE user_id = ?
+		ORDER BY uploaded_at DESC, rowid DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, model.Reversed, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get user orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []model.Order
+	for rows.Next() {
+		var o model.Order
+		var accrual sql.NullInt64
+		err := rows.Scan(&o.Number, &o.Status, &accrual, &o.UploadedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan order: %w", err)
+		}
+		o.Accrual = nullPoints(accrual)
+		orders = append(orders, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration: %w", err)
+	}
+
+	return orders, nil
+}
+
+func (s *SQLiteStorage) ReverseOrder(ctx context.Context, number string, reason string) error {
+	const query = `
+		UPDATE orders
+		SET status = ?, invalid_reason = NULLIF(?, '')
+		WHERE number = ? AND status = ?
+		RETURNING user_id, accrual`
+
+	const currentStatusQuery = `SELECT status FROM orders WHERE number = ?`
+
+	const insertHistoryQuery = `
+		INSERT INTO order_status_history (order_number, status, accrual, reason, changed_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?)`
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var userID int
+		var accrual sql.NullInt64
+		err := tx.QueryRowContext(ctx, query, model.Reversed, reason, number, model.Processed).Scan(&userID, &accrual)
+		if errors.Is(err, sql.ErrNoRows) {
+			var current model.OrderStatus
+			err = tx.QueryRowContext(ctx, currentStatusQuery, number).Scan(&current)
+			if errors.Is(err, sql.ErrNoRows) {
+				return errs.ErrOrderNotFound
+			}
+			if err != nil {
+				return fmt.Errorf("select order status: %w", err)
+			}
+			return rejectedTransition(current, model.Reversed)
+		}
+		if err != nil {
+			return fmt.Errorf("reverse order: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, insertHistoryQuery, number, model.Reversed, accrual, reason, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("insert status history: %w", err)
+		}
+
+		if accrual.Valid && accrual.Int64 != 0 {
+			return sqlitePostLedgerEntry(ctx, tx, model.LedgerEntry{
+				UserID:      userID,
+				Type:        model.LedgerReversal,
+				Amount:      -model.Points(accrual.Int64),
+				OrderNumber: number,
+				Comment:     reason,
+			})
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) GetUserOrder(ctx context.Context, user model.User, number string) (model.OrderDetails, error) {
+	const orderQuery = `
+		SELECT number, status, accrual, uploaded_at
+		FROM orders
+		WHERE number = ? AND user_id = ?`
+
+	const historyQuery = `
+		SELECT status, accrual, COALESCE(reason, ''), changed_at
+		FROM order_status_history
+		WHERE order_number = ?
+		ORDER BY id`
+
+	var details model.OrderDetails
+	o := &details.Order
+	var accrual sql.NullInt64
+	err := s.db.QueryRowContext(ctx, orderQuery, number, user.ID).Scan(&o.Number, &o.Status, &accrual, &o.UploadedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.OrderDetails{}, errs.ErrOrderNotFound
+		}
+		return model.OrderDetails{}, fmt.Errorf("get user order: %w", err)
+	}
+	o.Accrual = nullPoints(accrual)
+
+	rows, err := s.db.QueryContext(ctx, historyQuery, number)
+	if err != nil {
+		return model.OrderDetails{}, fmt.Errorf("get order history: %w", err)
+	}
+	defer rows.Close()
+
+	details.History = []model.OrderStatusChange{}
+	for rows.Next() {
+		var change model.OrderStatusChange
+		var accrual sql.NullInt64
+		err := rows.Scan(&change.Status, &accrual, &change.Reason, &change.ChangedAt)
+		if err != nil {
+			return model.OrderDetails{}, fmt.Errorf("scan order history: %w", err)
+		}
+		change.Accrual = nullPoints(accrual)
+		details.History = append(details.History, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return model.OrderDetails{}, fmt.Errorf("row iteration: %w", err)
+	}
+
+	return details, nil
+}
+
+func (s *SQLiteStorage) GetUnprocessedOrders(ctx context.Context) ([]model.Order, error) {
+	const query = `
+		SELECT number
+		FROM orders
+		WHERE status IN ('NEW', 'PROCESSING')
+			AND (next_check_at IS NULL OR next_check_at <= ?)
+		ORDER BY uploaded_at ASC, rowid ASC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("get unprocessed orders: %w", err)
+	}
+	defer rows.Close()
+
+	var list []model.Order
+	for rows.Next() {
+		var o model.Order
+		if err := rows.Scan(&o.Number); err != nil {
+			return nil, fmt.Errorf("scan unprocessed orders: %w", err)
+		}
+		list = append(list, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return list, nil
+}
+
+// ClaimUnprocessedOrders берёт в аренду до limit свободных необработанных заказов.
+// Запись в SQLite и так сериализована, поэтому SKIP LOCKED не нужен
+func (s *SQLiteStorage) ClaimUnprocessedOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]model.Order, error) {
+	const query = `
+		UPDATE orders
+		SET claimed_by = ?, claimed_until = ?
+		WHERE number IN (
+			SELECT number
+			FROM orders
+			WHERE status IN ('NEW', 'PROCESSING')
+				AND (next_check_at IS NULL OR next_check_at <= ?)
+				AND (claimed_until IS NULL OR claimed_until < ?)
+			ORDER BY uploaded_at ASC, rowid ASC
+			LIMIT ?
+		)
+		RETURNING number, status, uploaded_at, attempts
+	`
+
+	now := time.Now().UTC()
+
+	rows, err := s.db.QueryContext(ctx, query, owner, now.Add(lease), now, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim unprocessed orders: %w", err)
+	}
+	defer rows.Close()
+
+	var list []model.Order
+	for rows.Next() {
+		var o model.Order
+		if err := rows.Scan(&o.Number, &o.Status, &o.UploadedAt, &o.Attempts); err != nil {
+			return nil, fmt.Errorf("scan claimed order: %w", err)
+		}
+		list = append(list, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].UploadedAt.Before(list[j].UploadedAt)
+	})
+
+	return list, nil
+}
+
+// ScheduleNextCheck фиксирует очередную безрезультатную проверку заказа и откладывает следующую до nextCheckAt
+func (s *SQLiteStorage) ScheduleNextCheck(ctx context.Context, number string, nextCheckAt time.Time) error {
+	const query = `
+		UPDATE orders
+		SET attempts = attempts + 1, last_checked_at = ?, next_check_at = ?
+		WHERE number = ?
+	`
+
+	_, err := s.db.ExecContext(ctx, query, time.Now().UTC(), nextCheckAt.UTC(), number)
+	if err != nil {
+		return fmt.Errorf("schedule next check: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseOrder снимает аренду, если она всё ещё принадлежит owner
+func (s *SQLiteStorage) ReleaseOrder(ctx context.Context, owner string, number string) error {
+	const query = `
+		UPDATE orders
+		SET claimed_by = NULL, claimed_until = NULL
+		WHERE number = ? AND claimed_by = ?
+	`
+
+	_, err := s.db.ExecContext(ctx, query, number, owner)
+	if err != nil {
+		return fmt.Errorf("release order: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) UpdateOrder(ctx context.Context, order model.Order) error {
+	// статус меняется, только если переход допустим; так обработанный заказ не откатится назад
+	// и его начисление не перезапишется
+	previous := previousStatuses(order.Status)
+	query := `
+		UPDATE orders
+		SET status = ?, accrual = ?, invalid_reason = NULLIF(?, '')
+		WHERE number = ? AND status IN (` + inPlaceholders(len(previous)) + `)
+		RETURNING user_id`
+
+	const currentStatusQuery = `SELECT status FROM orders WHERE number = ?`
+
+	const insertHistoryQuery = `
+		INSERT INTO order_status_history (order_number, status, accrual, reason, changed_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?)`
+
+	args := []any{order.Status, pointsOrNull(order.Accrual), order.InvalidReason, order.Number}
+	for _, s := range previous {
+		args = append(args, s)
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var userID int
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			var current model.OrderStatus
+			err = tx.QueryRowContext(ctx, currentStatusQuery, order.Number).Scan(&current)
+			if errors.Is(err, sql.ErrNoRows) {
+				return errs.ErrOrderNotFound
+			}
+			if err != nil {
+				return fmt.Errorf("select order status: %w", err)
+			}
+			return rejectedTransition(current, order.Status)
+		}
+		if err != nil {
+			return fmt.Errorf("update order status: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, insertHistoryQuery,
+			order.Number, order.Status, pointsOrNull(order.Accrual), order.InvalidReason, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("insert status history: %w", err)
+		}
+
+		if order.Status == model.Processed && order.Accrual != nil && *order.Accrual != 0 {
+			return sqlitePostLedgerEntry(ctx, tx, model.LedgerEntry{
+				UserID:      userID,
+				Type:        model.LedgerAccrual,
+				Amount:      *order.Accrual,
+				OrderNumber: order.Number,
+			})
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) GetUserBalance(ctx context.Context, user model.User) (model.Balance, error) {
+	const query = `SELECT current, withdrawn FROM user_balances WHERE user_id = ?`
+
+	var current, withdrawn int64
+	err := s.db.QueryRowContext(ctx, query, user.ID).Scan(&current, &withdrawn)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Balance{}, nil // движений по счёту ещё не было
+		}
+		return model.Balance{}, fmt.Errorf("get balance: %w", err)
+	}
+
+	return model.Balance{Current: model.Points(current), Withdrawn: model.Points(withdrawn)}, nil
+}
+
+func (s *SQLiteStorage) WithdrawBalance(ctx context.Context, user model.User, order string, sum model.Points) error {
+	const checkWithdrawalQuery = `SELECT EXISTS (SELECT 1 FROM withdrawals WHERE order_number = ?)`
+
+	const checkBalanceQuery = `SELECT COALESCE((SELECT current FROM user_balances WHERE user_id = ?), 0)`
+
+	const insertWithdrawalQuery = `
+		INSERT INTO withdrawals (user_id, order_number, sum, processed_at)
+		VALUES (?, ?, ?, ?)
+	`
+
+	// транзакция открывается с BEGIN IMMEDIATE, параллельные списания идут по очереди
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, checkWithdrawalQuery, order).Scan(&exists); err != nil {
+			return fmt.Errorf("check withdrawal: %w", err)
+		}
+		if exists {
+			return errs.ErrWithdrawalExists
+		}
+
+		var balance int64
+		if err := tx.QueryRowContext(ctx, checkBalanceQuery, user.ID).Scan(&balance); err != nil {
+			return fmt.Errorf("check balance: %w", err)
+		}
+
+		if model.Points(balance) < sum {
+			return errs.ErrInsufficientFunds
+		}
+
+		_, err := tx.ExecContext(ctx, insertWithdrawalQuery, user.ID, order, int64(sum), time.Now().UTC())
+		if err != nil {
+			if isUniqueViolation(err) {
+				return errs.ErrWithdrawalExists
+			}
+			return fmt.Errorf("insert withdrawal: %w", err)
+		}
+
+		return sqlitePostLedgerEntry(ctx, tx, model.LedgerEntry{
+			UserID:      user.ID,
+			Type:        model.LedgerWithdrawal,
+			Amount:      -sum,
+			OrderNumber: order,
+		})
+	})
+}
+
+func (s *SQLiteStorage) GetWithdrawals(ctx context.Context, user model.User) ([]model.Withdrawal, error) {
+	const query = `
+		SELECT order_number, sum, processed_at
+		FROM withdrawals
+		WHERE user_id = ?
+		ORDER BY processed_at DESC, id DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get withdrawals: %w", err)
+	}
+	defer rows.Close()
+
+	var list []model.Withdrawal
+	for rows.Next() {
+		var w model.Withdrawal
+		var sum int64
+		if err := rows.Scan(&w.Order, &sum, &w.ProcessedAt); err != nil {
+			return nil, fmt.Errorf("scan withdrawal: %w", err)
+		}
+		w.Sum = model.Points(sum)
+		list = append(list, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return list, nil
+}
+
+func (s *SQLiteStorage) GetWithdrawal(ctx context.Context, user model.User, order string) (model.Withdrawal, error) {
+	const query = `
+		SELECT order_number, sum, processed_at
+		FROM withdrawals
+		WHERE user_id = ? AND order_number = ?
+	`
+
+	var w model.Withdrawal
+	var sum int64
+	err := s.db.QueryRowContext(ctx, query, user.ID, order).Scan(&w.Order, &sum, &w.ProcessedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Withdrawal{}, errs.ErrWithdrawalNotFound
+		}
+		return model.Withdrawal{}, fmt.Errorf("get withdrawal: %w", err)
+	}
+	w.Sum = model.Points(sum)
+
+	return w, nil
+}
+
+func sqlitePostLedgerEntry(ctx context.Context, tx *sql.Tx, entry model.LedgerEntry) error {
+	const nextTransactionQuery = `SELECT COALESCE(MAX(transaction_id), 0) + 1 FROM ledger_entries`
+
+	const insertEntryQuery = `
+		INSERT INTO ledger_entries (transaction_id, user_id, account, entry_type, amount, order_number, comment, created_at)
+		VALUES (?, ?, 'user', ?, ?, NULLIF(?, ''), ?, ?), (?, ?, 'system', ?, ?, NULLIF(?, ''), ?, ?)
+	`
+
+	const updateBalanceQuery = `
+		INSERT INTO user_balances (user_id, current, withdrawn)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			current = current + excluded.current,
+			withdrawn = withdrawn + excluded.withdrawn
+	`
+
+	var transactionID int64
+	if err := tx.QueryRowContext(ctx, nextTransactionQuery).Scan(&transactionID); err != nil {
+		return fmt.Errorf("next ledger transaction: %w", err)
+	}
+
+	now := time.Now().UTC()
+	_, err := tx.ExecContext(ctx, insertEntryQuery,
+		transactionID, entry.UserID, entry.Type, int64(entry.Amount), entry.OrderNumber, entry.Comment, now,
+		transactionID, entry.UserID, entry.Type, -int64(entry.Amount), entry.OrderNumber, entry.Comment, now)
+	if err != nil {
+		return fmt.Errorf("insert ledger entry: %w", err)
+	}
+
+	var withdrawn model.Points
+	if entry.Type.CountsAsWithdrawn() {
+		withdrawn = -entry.Amount
+	}
+
+	_, err = tx.ExecContext(ctx, updateBalanceQuery, entry.UserID, int64(entry.Amount), int64(withdrawn))
+	if err != nil {
+		return fmt.Errorf("update user balance: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetLedgerEntries(ctx context.Context, user model.User) ([]model.LedgerEntry, error) {
+	const query = `
+		SELECT id, transaction_id, user_id, entry_type, amount, COALESCE(order_number, ''), comment, created_at
+		FROM ledger_entries
+		WHERE user_id = ? AND account = 'user'
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	var list []model.LedgerEntry
+	for rows.Next() {
+		var e model.LedgerEntry
+		var amount int64
+		err := rows.Scan(&e.ID, &e.TransactionID, &e.UserID, &e.Type, &amount, &e.OrderNumber, &e.Comment, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan ledger entry: %w", err)
+		}
+		e.Amount = model.Points(amount)
+		list = append(list, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return list, nil
+}
+
+// AdjustBalance — ручная корректировка баланса, amount со знаком
+func (s *SQLiteStorage) AdjustBalance(ctx context.Context, user model.User, amount model.Points, comment string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return sqlitePostLedgerEntry(ctx, tx, model.LedgerEntry{
+			UserID:  user.ID,
+			Type:    model.LedgerAdjustment,
+			Amount:  amount,
+			Comment: comment,
+		})
+	})
+}
+
+func (s *SQLiteStorage) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (model.IdempotencyRecord, bool, error) {
+	const deleteExpiredQuery = `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND expires_at < ?`
+
+	const insertQuery = `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, key) DO NOTHING
+	`
+
+	const selectQuery = `
+		SELECT user_id, key, fingerprint, status_code, content_type, body, expires_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ?
+	`
+
+	var existing model.IdempotencyRecord
+	created := false
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, deleteExpiredQuery, record.UserID, record.Key, time.Now().UTC()); err != nil {
+			return fmt.Errorf("delete expired idempotency key: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, insertQuery, record.UserID, record.Key, record.Fingerprint, record.ExpiresAt.UTC())
+		if err != nil {
+			return fmt.Errorf("insert idempotency key: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			created = true
+			return nil
+		}
+
+		err = tx.QueryRowContext(ctx, selectQuery, record.UserID, record.Key).Scan(
+			&existing.UserID, &existing.Key, &existing.Fingerprint, &existing.StatusCode,
+			&existing.ContentType, &existing.Body, &existing.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("select idempotency key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.IdempotencyRecord{}, false, err
+	}
+
+	if created {
+		return record, true, nil
+	}
+
+	return existing, false, nil
+}
+
+func (s *SQLiteStorage) SaveIdempotencyResponse(ctx context.Context, record model.IdempotencyRecord) error {
+	const query = `
+		UPDATE idempotency_keys
+		SET status_code = ?, content_type = ?, body = ?, expires_at = ?
+		WHERE user_id = ? AND key = ? AND status_code = 0
+	`
+
+	_, err := s.db.ExecContext(ctx, query, record.StatusCode, record.ContentType, record.Body, record.ExpiresAt.UTC(), record.UserID, record.Key)
+	if err != nil {
+		return fmt.Errorf("save idempotency response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteIdempotencyKey(ctx context.Context, userID int, key string) error {
+	const query = `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?`
+
+	_, err := s.db.ExecContext(ctx, query, userID, key)
+	if err != nil {
+		return fmt.Errorf("delete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) CreateRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	const query = `
+		INSERT INTO refresh_tokens (token_hash, user_id, family_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, token.Hash, token.UserID, token.FamilyID, time.Now().UTC(), token.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) RotateRefreshToken(ctx context.Context, hash string, next model.RefreshToken) (int, error) {
+	const selectQuery = `
+		SELECT user_id, family_id, expires_at, revoked_at IS NOT NULL
+		FROM refresh_tokens
+		WHERE token_hash = ?
+	`
+
+	const revokeFamilyQuery = `UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`
+
+	const revokeQuery = `UPDATE refresh_tokens SET revoked_at = ? WHERE token_hash = ?`
+
+	const insertQuery = `
+		INSERT INTO refresh_tokens (token_hash, user_id, family_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	// отзыв семейства должен сохраниться, поэтому повтор токена — не ошибка транзакции
+	reused := false
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var expiresAt time.Time
+		var revoked bool
+		err := tx.QueryRowContext(ctx, selectQuery, hash).Scan(&next.UserID, &next.FamilyID, &expiresAt, &revoked)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errs.ErrInvalidToken
+			}
+			return fmt.Errorf("select refresh token: %w", err)
+		}
+
+		now := time.Now().UTC()
+		if revoked {
+			reused = true
+			if _, err := tx.ExecContext(ctx, revokeFamilyQuery, now, next.FamilyID); err != nil {
+				return fmt.Errorf("revoke refresh token family: %w", err)
+			}
+			return nil
+		}
+
+		if !expiresAt.After(now) {
+			return errs.ErrInvalidToken
+		}
+
+		if _, err := tx.ExecContext(ctx, revokeQuery, now, hash); err != nil {
+			return fmt.Errorf("revoke refresh token: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, insertQuery, next.Hash, next.UserID, next.FamilyID, now, next.ExpiresAt.UTC())
+		if err != nil {
+			return fmt.Errorf("insert refresh token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if reused {
+		return 0, errs.ErrRefreshTokenReused
+	}
+
+	return next.UserID, nil
+}
+
+func (s *SQLiteStorage) RevokeRefreshToken(ctx context.Context, userID int, hash string) error {
+	const query = `
+		UPDATE refresh_tokens SET revoked_at = ?
+		WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = ? AND user_id = ?)
+			AND revoked_at IS NULL
+	`
+
+	_, err := s.db.ExecContext(ctx, query, time.Now().UTC(), hash, userID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	const query = `UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+
+	if _, err := s.db.ExecContext(ctx, query, time.Now().UTC(), userID); err != nil {
+		return fmt.Errorf("revoke user sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const deleteExpiredQuery = `DELETE FROM revoked_tokens WHERE expires_at < ?`
+
+	const insertQuery = `
+		INSERT INTO revoked_tokens (jti, expires_at)
+		VALUES (?, ?)
+		ON CONFLICT (jti) DO NOTHING
+	`
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, deleteExpiredQuery, time.Now().UTC()); err != nil {
+			return fmt.Errorf("delete expired revoked tokens: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, insertQuery, jti, expiresAt.UTC()); err != nil {
+			return fmt.Errorf("insert revoked token: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const query = `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)`
+
+	var revoked bool
+	if err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("check revoked token: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (s *SQLiteStorage) RecordLoginAttempt(ctx context.Context, key string, limit model.LoginLimit, now time.Time) (model.LoginAttempts, bool, error) {
+	const ensureQuery = `INSERT INTO login_attempts (key, updated_at) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`
+
+	const selectQuery = `SELECT failures, locked_until, updated_at FROM login_attempts WHERE key = ?`
+
+	const updateQuery = `UPDATE login_attempts SET failures = ?, locked_until = ?, updated_at = ? WHERE key = ?`
+
+	attempts := model.LoginAttempts{Key: key}
+	var admitted bool
+	now = now.UTC()
+	windowStart := now.Add(-limit.Lockout)
+
+	// транзакция immediate, так что параллельные попытки засчитываются по одной
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, ensureQuery, key, now); err != nil {
+			return fmt.Errorf("insert login attempts: %w", err)
+		}
+
+		var lockedUntil sql.NullTime
+		var updatedAt time.Time
+		if err := tx.QueryRowContext(ctx, selectQuery, key).Scan(&attempts.Failures, &lockedUntil, &updatedAt); err != nil {
+			return fmt.Errorf("select login attempts: %w", err)
+		}
+		if lockedUntil.Valid && lockedUntil.Time.After(now) {
+			attempts.LockedUntil = lockedUntil.Time
+			return nil
+		}
+
+		admitted = true
+		attempts.Failures = nextFailures(attempts.Failures, updatedAt, windowStart)
+		var newLock sql.NullTime
+		if lock := limit.LockFor(attempts.Failures); lock > 0 {
+			attempts.LockedUntil = now.Add(lock)
+			newLock = sql.NullTime{Time: attempts.LockedUntil, Valid: true}
+		}
+
+		if _, err := tx.ExecContext(ctx, updateQuery, attempts.Failures, newLock, now, key); err != nil {
+			return fmt.Errorf("record login attempt: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.LoginAttempts{}, false, err
+	}
+
+	return attempts, admitted, nil
+}
+
+func (s *SQLiteStorage) DeleteStaleLoginAttempts(ctx context.Context, before, now time.Time, limit int) (int, error) {
+	const query = `
+		DELETE FROM login_attempts
+		WHERE key IN (
+			SELECT key FROM login_attempts
+			WHERE updated_at < ? AND (locked_until IS NULL OR locked_until < ?)
+			LIMIT ?
+		)
+	`
+
+	res, err := s.db.ExecContext(ctx, query, before.UTC(), now.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("delete stale login attempts: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (s *SQLiteStorage) ForgiveLoginAttempt(ctx context.Context, key string) error {
+	const query = `UPDATE login_attempts SET failures = MAX(failures - 1, 0) WHERE key = ?`
+
+	if _, err := s.db.ExecContext(ctx, query, key); err != nil {
+		return fmt.Errorf("forgive login attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) ResetLoginAttempts(ctx context.Context, key string) error {
+	const query = `DELETE FROM login_attempts WHERE key = ?`
+
+	if _, err := s.db.ExecContext(ctx, query, key); err != nil {
+		return fmt.Errorf("reset login attempts: %w", err)
+	}
+
+	return nil
+}
+
+func nullPoints(v sql.NullInt64) *model.Points {
+	if !v.Valid {
+		return nil
+	}
+	p := model.Points(v.Int64)
+	return &p
+}
+
+func pointsOrNull(p *model.Points) any {
+	if p == nil {
+		return nil
+	}
+	return int64(*p)
+}
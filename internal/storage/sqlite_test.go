@@ -0,0 +1,102 @@
+//go:build sqlite
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/migrate"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteStorage {
+	t.Helper()
+
+	s, err := NewSQLiteStorage(context.Background(), filepath.Join(t.TempDir(), "gophermart.db"))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return newTestSQLite(t)
+	})
+}
+
+func TestSQLiteMigrateDownUp(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+
+	migrator, err := s.Migrator()
+	require.NoError(t, err)
+
+	for {
+		err := migrator.Down(ctx)
+		if errors.Is(err, migrate.ErrNoAppliedMigrations) {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	status, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, st := range status {
+		require.Nil(t, st.AppliedAt)
+	}
+
+	require.NoError(t, migrator.Up(ctx))
+	require.NoError(t, s.CreateUser(ctx, "alice", "hash"))
+}
+
+func TestSQLiteConcurrentWithdrawals(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateUser(ctx, "alice", "hash"))
+	user, _, err := s.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	require.NoError(t, s.AdjustBalance(ctx, user, 100*model.Point, "initial"))
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	errCh := make(chan error, attempts)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errCh <- s.WithdrawBalance(ctx, user, fmt.Sprintf("order-%d", i), 10*model.Point)
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+
+	succeeded := 0
+	for err := range errCh {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		require.ErrorIs(t, err, errs.ErrInsufficientFunds)
+	}
+
+	require.Equal(t, 10, succeeded)
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0, Withdrawn: 100 * model.Point}, balance)
+
+	entries, err := s.GetLedgerEntries(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, entries, 11)
+}
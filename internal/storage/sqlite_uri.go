@@ -0,0 +1,14 @@
+package storage
+
+import "strings"
+
+// SQLitePath извлекает путь к файлу базы из URI вида file:///path или sqlite:///path.
+// Само хранилище SQLite собирается только с тегом sqlite, см. sqlite.go
+func SQLitePath(databaseURI string) (string, bool) {
+	for _, prefix := range []string{"file://", "sqlite://"} {
+		if strings.HasPrefix(databaseURI, prefix) {
+			return strings.TrimPrefix(databaseURI, prefix), true
+		}
+	}
+	return "", false
+}
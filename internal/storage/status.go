@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/model"
+)
+
+// rejectedTransition объясняет, почему условный UPDATE не затронул существующий заказ.
+// Повтор того же статуса — не ошибка: система начислений может прислать его несколько раз
+func rejectedTransition(current, next model.OrderStatus) error {
+	if current == next {
+		return nil
+	}
+	return fmt.Errorf("%w: %s -> %s", errs.ErrIllegalStatusTransition, current, next)
+}
+
+func previousStatuses(next model.OrderStatus) []string {
+	var list []string
+	for _, s := range model.PreviousStatuses(next) {
+		list = append(list, string(s))
+	}
+	return list
+}
+
+// inPlaceholders — "?, ?, ?" для n аргументов; для пустого списка условие IN () должно быть ложным
+func inPlaceholders(n int) string {
+	if n == 0 {
+		return "NULL"
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
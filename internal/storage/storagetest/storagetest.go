@@ -0,0 +1,824 @@
+// Package storagetest — общий набор тестов, которым должна удовлетворять любая реализация хранилища.
+package storagetest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/and161185/loyalty/internal/errs"
+	"github.com/and161185/loyalty/internal/model"
+	"github.com/and161185/loyalty/internal/server"
+	"github.com/stretchr/testify/require"
+)
+
+type Storage interface {
+	server.UserStorage
+	server.OrderStorage
+	server.BalanceStorage
+	server.IdempotencyStorage
+	server.SessionStorage
+	server.LoginAttemptStorage
+}
+
+// Run прогоняет набор на хранилищах, которые возвращает newStorage; для каждого подтеста
+// newStorage должна отдавать пустое хранилище
+func Run(t *testing.T, newStorage func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Storage)
+	}{
+		{"Users", testUsers},
+		{"OrderOwnership", testOrderOwnership},
+		{"UserOrders", testUserOrders},
+		{"Balance", testBalance},
+		{"Withdrawals", testWithdrawals},
+		{"UnprocessedOrders", testUnprocessedOrders},
+		{"OrderClaims", testOrderClaims},
+		{"OrderSchedule", testOrderSchedule},
+		{"StatusTransitions", testStatusTransitions},
+		{"OrderHistory", testOrderHistory},
+		{"Reversal", testReversal},
+		{"Idempotency", testIdempotency},
+		{"Sessions", testSessions},
+		{"LoginAttempts", testLoginAttempts},
+		{"ConcurrentLoginAttempts", testConcurrentLoginAttempts},
+		{"DeleteStaleLoginAttempts", testDeleteStaleLoginAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, s Storage, login string) model.User {
+	t.Helper()
+
+	ctx := context.Background()
+	require.NoError(t, s.CreateUser(ctx, login, "hash-"+login))
+
+	user, _, err := s.GetUserByLogin(ctx, login)
+	require.NoError(t, err)
+
+	return user
+}
+
+// accrue загружает заказ и проводит его с начислением
+func accrue(t *testing.T, s Storage, user model.User, number string, accrual model.Points) {
+	t.Helper()
+
+	ctx := context.Background()
+	_, err := s.AddOrder(ctx, user, model.Order{Number: number})
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: number, Status: model.Processed, Accrual: &accrual}))
+}
+
+func testUsers(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateUser(ctx, "alice", "hash"))
+	require.ErrorIs(t, s.CreateUser(ctx, "alice", "other"), errs.ErrLoginAlreadyExists)
+
+	user, hash, err := s.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "alice", user.Login)
+	require.Equal(t, "hash", hash)
+
+	byID, err := s.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, user, byID)
+
+	_, _, err = s.GetUserByLogin(ctx, "bob")
+	require.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	_, err = s.GetUserByID(ctx, user.ID+1000)
+	require.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	require.NoError(t, s.UpdatePassword(ctx, user.ID, "new-hash"))
+	changed, hash, err := s.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "new-hash", hash)
+	require.Equal(t, user.TokenVersion+1, changed.TokenVersion)
+
+	byID, err = s.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Equal(t, changed, byID)
+
+	require.ErrorIs(t, s.UpdatePassword(ctx, user.ID+1000, "hash"), errs.ErrUserNotFound)
+}
+
+func testOrderOwnership(t *testing.T, s Storage) {
+	ctx := context.Background()
+	alice := createUser(t, s, "alice")
+	bob := createUser(t, s, "bob")
+
+	code, err := s.AddOrder(ctx, alice, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 202, code, "new order")
+
+	code, err = s.AddOrder(ctx, alice, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 200, code, "same user, same order")
+
+	code, err = s.AddOrder(ctx, bob, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 409, code, "order of another user")
+
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processing}))
+
+	code, err = s.AddOrder(ctx, alice, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Equal(t, 202, code, "same user, order in processing")
+
+	orders, err := s.GetUserOrders(ctx, bob)
+	require.NoError(t, err)
+	require.Empty(t, orders)
+}
+
+func testUserOrders(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+
+	orders, err := s.GetUserOrders(ctx, user)
+	require.NoError(t, err)
+	require.Empty(t, orders)
+
+	for _, number := range []string{"12345678903", "2377225624", "4561261212345467"} {
+		_, err := s.AddOrder(ctx, user, model.Order{Number: number})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	accrual := 500 * model.Point
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "2377225624", Status: model.Processed, Accrual: &accrual}))
+
+	orders, err = s.GetUserOrders(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, orders, 3)
+
+	// сначала новые
+	require.Equal(t, "4561261212345467", orders[0].Number)
+	require.Equal(t, "2377225624", orders[1].Number)
+	require.Equal(t, "12345678903", orders[2].Number)
+
+	require.Equal(t, model.New, orders[0].Status)
+	require.Nil(t, orders[0].Accrual)
+	require.False(t, orders[0].UploadedAt.IsZero())
+
+	require.Equal(t, model.Processed, orders[1].Status)
+	require.NotNil(t, orders[1].Accrual)
+	require.Equal(t, accrual, *orders[1].Accrual)
+}
+
+func testBalance(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+	other := createUser(t, s, "bob")
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{}, balance)
+
+	accrue(t, s, user, "12345678903", 72998)
+	accrue(t, s, user, "2377225624", 1002)
+	accrue(t, s, other, "4561261212345467", 100*model.Point)
+
+	// незавершённые заказы на баланс не влияют
+	_, err = s.AddOrder(ctx, user, model.Order{Number: "79927398713"})
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "79927398713", Status: model.Processing}))
+
+	// повторное обновление обработанного заказа не начисляет баллы второй раз
+	again := model.Points(72998)
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processed, Accrual: &again}))
+
+	balance, err = s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 740 * model.Point}, balance)
+
+	require.NoError(t, s.WithdrawBalance(ctx, user, "2377225624", 240*model.Point+50))
+	require.ErrorIs(t, s.WithdrawBalance(ctx, user, "49927398716", 500*model.Point), errs.ErrInsufficientFunds)
+
+	balance, err = s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 499*model.Point + 50, Withdrawn: 240*model.Point + 50}, balance)
+
+	require.NoError(t, s.WithdrawBalance(ctx, user, "49927398716", 499*model.Point+50))
+
+	balance, err = s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0, Withdrawn: 740 * model.Point}, balance)
+
+	balance, err = s.GetUserBalance(ctx, other)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 100 * model.Point}, balance)
+}
+
+func testWithdrawals(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+	other := createUser(t, s, "bob")
+
+	list, err := s.GetWithdrawals(ctx, user)
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	accrue(t, s, user, "12345678903", 100*model.Point)
+	accrue(t, s, other, "2377225624", 100*model.Point)
+
+	for _, order := range []string{"79927398713", "49927398716", "1234567812345670"} {
+		require.NoError(t, s.WithdrawBalance(ctx, user, order, 10*model.Point))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	require.ErrorIs(t, s.WithdrawBalance(ctx, user, "79927398713", 10*model.Point), errs.ErrWithdrawalExists)
+	require.ErrorIs(t, s.WithdrawBalance(ctx, other, "79927398713", 10*model.Point), errs.ErrWithdrawalExists)
+
+	list, err = s.GetWithdrawals(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+
+	// сначала новые
+	require.Equal(t, "1234567812345670", list[0].Order)
+	require.Equal(t, "49927398716", list[1].Order)
+	require.Equal(t, "79927398713", list[2].Order)
+	require.Equal(t, 10*model.Point, list[0].Sum)
+	require.False(t, list[0].ProcessedAt.IsZero())
+
+	w, err := s.GetWithdrawal(ctx, user, "49927398716")
+	require.NoError(t, err)
+	require.Equal(t, list[1], w)
+
+	_, err = s.GetWithdrawal(ctx, other, "49927398716")
+	require.ErrorIs(t, err, errs.ErrWithdrawalNotFound)
+
+	list, err = s.GetWithdrawals(ctx, other)
+	require.NoError(t, err)
+	require.Empty(t, list)
+}
+
+func testUnprocessedOrders(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+
+	orders, err := s.GetUnprocessedOrders(ctx)
+	require.NoError(t, err)
+	require.Empty(t, orders)
+
+	numbers := []string{"12345678903", "2377225624", "4561261212345467", "79927398713"}
+	for _, number := range numbers {
+		_, err := s.AddOrder(ctx, user, model.Order{Number: number})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	accrual := 10 * model.Point
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "2377225624", Status: model.Processing}))
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "4561261212345467", Status: model.Processed, Accrual: &accrual}))
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "79927398713", Status: model.Invalid}))
+
+	orders, err = s.GetUnprocessedOrders(ctx)
+	require.NoError(t, err)
+
+	// сначала старые
+	var got []string
+	for _, o := range orders {
+		got = append(got, o.Number)
+	}
+	require.Equal(t, []string{"12345678903", "2377225624"}, got)
+}
+
+func claimedNumbers(t *testing.T, s Storage, owner string, limit int, lease time.Duration) []string {
+	t.Helper()
+
+	orders, err := s.ClaimUnprocessedOrders(context.Background(), owner, limit, lease)
+	require.NoError(t, err)
+
+	var numbers []string
+	for _, o := range orders {
+		require.Equal(t, model.New, o.Status)
+		numbers = append(numbers, o.Number)
+	}
+	return numbers
+}
+
+func testOrderClaims(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+
+	numbers := []string{"12345678903", "2377225624", "4561261212345467", "79927398713"}
+	for _, number := range numbers {
+		_, err := s.AddOrder(ctx, user, model.Order{Number: number})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "79927398713", Status: model.Invalid}))
+
+	// экземпляры делят работу, а не дублируют её
+	require.Equal(t, []string{"12345678903", "2377225624"}, claimedNumbers(t, s, "a", 2, time.Minute))
+	require.Equal(t, []string{"4561261212345467"}, claimedNumbers(t, s, "b", 10, time.Minute))
+	require.Empty(t, claimedNumbers(t, s, "c", 10, time.Minute))
+
+	// чужую аренду снять нельзя
+	require.NoError(t, s.ReleaseOrder(ctx, "b", "12345678903"))
+	require.Empty(t, claimedNumbers(t, s, "c", 10, time.Minute))
+
+	require.NoError(t, s.ReleaseOrder(ctx, "a", "12345678903"))
+	require.Equal(t, []string{"12345678903"}, claimedNumbers(t, s, "c", 10, time.Minute))
+
+	// истёкшую аренду забирает другой экземпляр
+	require.NoError(t, s.ReleaseOrder(ctx, "b", "4561261212345467"))
+	require.Equal(t, []string{"4561261212345467"}, claimedNumbers(t, s, "b", 10, time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, []string{"4561261212345467"}, claimedNumbers(t, s, "a", 10, time.Minute))
+}
+
+func testOrderSchedule(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+
+	_, err := s.AddOrder(ctx, user, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+
+	orders, err := s.ClaimUnprocessedOrders(ctx, "a", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, 0, orders[0].Attempts)
+
+	// отложенный заказ не выдаётся, пока не подойдёт срок
+	require.NoError(t, s.ScheduleNextCheck(ctx, "12345678903", time.Now().Add(time.Hour)))
+	require.NoError(t, s.ReleaseOrder(ctx, "a", "12345678903"))
+
+	orders, err = s.GetUnprocessedOrders(ctx)
+	require.NoError(t, err)
+	require.Empty(t, orders)
+	require.Empty(t, claimedNumbers(t, s, "a", 10, time.Minute))
+
+	require.NoError(t, s.ScheduleNextCheck(ctx, "12345678903", time.Now().Add(-time.Second)))
+
+	orders, err = s.GetUnprocessedOrders(ctx)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	orders, err = s.ClaimUnprocessedOrders(ctx, "a", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, 2, orders[0].Attempts)
+
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Invalid, InvalidReason: "expired"}))
+
+	list, err := s.GetUserOrders(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, model.Invalid, list[0].Status)
+
+	orders, err = s.GetUnprocessedOrders(ctx)
+	require.NoError(t, err)
+	require.Empty(t, orders)
+}
+
+func testStatusTransitions(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+
+	_, err := s.AddOrder(ctx, user, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+
+	// статус системы начислений напрямую не пишется, его переводит вызывающий
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Registered}), errs.ErrIllegalStatusTransition)
+
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processing}))
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processing}))
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.New}), errs.ErrIllegalStatusTransition)
+
+	accrual := 10 * model.Point
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processed, Accrual: &accrual}))
+
+	// окончательный статус не меняется, а повтор не перезаписывает начисление
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processing}), errs.ErrIllegalStatusTransition)
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Invalid}), errs.ErrIllegalStatusTransition)
+
+	other := 99 * model.Point
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processed, Accrual: &other}))
+
+	orders, err := s.GetUserOrders(ctx, user)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, model.Processed, orders[0].Status)
+	require.Equal(t, accrual, *orders[0].Accrual)
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, accrual, balance.Current)
+
+	// неизвестный заказ не обновляется и ничего не начисляет
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "2377225624", Status: model.Processed, Accrual: &accrual}), errs.ErrOrderNotFound)
+
+	balance, err = s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, accrual, balance.Current)
+}
+
+func testOrderHistory(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+	other := createUser(t, s, "bob")
+
+	_, err := s.GetUserOrder(ctx, user, "12345678903")
+	require.ErrorIs(t, err, errs.ErrOrderNotFound)
+
+	_, err = s.AddOrder(ctx, user, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+
+	// повторная загрузка историю не дополняет
+	_, err = s.AddOrder(ctx, user, model.Order{Number: "12345678903"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processing}))
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processing}))
+
+	accrual := 15 * model.Point
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processed, Accrual: &accrual}))
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Invalid}), errs.ErrIllegalStatusTransition)
+
+	details, err := s.GetUserOrder(ctx, user, "12345678903")
+	require.NoError(t, err)
+	require.Equal(t, "12345678903", details.Number)
+	require.Equal(t, model.Processed, details.Status)
+	require.Equal(t, accrual, *details.Accrual)
+
+	require.Len(t, details.History, 3)
+	require.Equal(t, model.New, details.History[0].Status)
+	require.Nil(t, details.History[0].Accrual)
+	require.Equal(t, model.Processing, details.History[1].Status)
+	require.Equal(t, model.Processed, details.History[2].Status)
+	require.Equal(t, accrual, *details.History[2].Accrual)
+	for i := 1; i < len(details.History); i++ {
+		require.False(t, details.History[i].ChangedAt.Before(details.History[i-1].ChangedAt))
+	}
+
+	_, err = s.AddOrder(ctx, user, model.Order{Number: "79927398713"})
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateOrder(ctx, model.Order{Number: "79927398713", Status: model.Invalid, InvalidReason: "expired"}))
+
+	details, err = s.GetUserOrder(ctx, user, "79927398713")
+	require.NoError(t, err)
+	require.Len(t, details.History, 2)
+	require.Equal(t, "expired", details.History[1].Reason)
+
+	// чужой заказ не отличается от несуществующего
+	_, err = s.GetUserOrder(ctx, other, "12345678903")
+	require.ErrorIs(t, err, errs.ErrOrderNotFound)
+}
+
+func testReversal(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+
+	require.ErrorIs(t, s.ReverseOrder(ctx, "12345678903", ""), errs.ErrOrderNotFound)
+
+	accrue(t, s, user, "12345678903", 100*model.Point)
+	accrue(t, s, user, "2377225624", 30*model.Point)
+	require.NoError(t, s.WithdrawBalance(ctx, user, "49927398716", 120*model.Point))
+
+	// баллы уже потрачены — отмена уводит баланс в минус, списанное не меняется
+	require.NoError(t, s.ReverseOrder(ctx, "12345678903", "purchase cancelled"))
+	require.NoError(t, s.ReverseOrder(ctx, "12345678903", "purchase cancelled"))
+
+	balance, err := s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: -90 * model.Point, Withdrawn: 120 * model.Point}, balance)
+
+	// пока долг не погашен, списывать нечего
+	require.ErrorIs(t, s.WithdrawBalance(ctx, user, "79927398713", model.Point), errs.ErrInsufficientFunds)
+
+	details, err := s.GetUserOrder(ctx, user, "12345678903")
+	require.NoError(t, err)
+	require.Equal(t, model.Reversed, details.Status)
+	require.Len(t, details.History, 3)
+	require.Equal(t, model.Reversed, details.History[2].Status)
+	require.Equal(t, "purchase cancelled", details.History[2].Reason)
+
+	// в списке у отменённого заказа начисления нет, у остальных остаётся
+	orders, err := s.GetUserOrders(ctx, user)
+	require.NoError(t, err)
+	for _, o := range orders {
+		if o.Number == "12345678903" {
+			require.Equal(t, model.Reversed, o.Status)
+			require.Nil(t, o.Accrual)
+		} else {
+			require.NotNil(t, o.Accrual)
+		}
+	}
+
+	// отменённый заказ не возвращается в PROCESSED и не начисляется повторно
+	again := 100 * model.Point
+	require.ErrorIs(t, s.UpdateOrder(ctx, model.Order{Number: "12345678903", Status: model.Processed, Accrual: &again}), errs.ErrIllegalStatusTransition)
+
+	// новое начисление гасит долг
+	accrue(t, s, user, "4561261212345467", 100*model.Point)
+
+	balance, err = s.GetUserBalance(ctx, user)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 10 * model.Point, Withdrawn: 120 * model.Point}, balance)
+
+	// отменить можно только обработанный заказ
+	_, err = s.AddOrder(ctx, user, model.Order{Number: "79927398713"})
+	require.NoError(t, err)
+	require.ErrorIs(t, s.ReverseOrder(ctx, "79927398713", ""), errs.ErrIllegalStatusTransition)
+}
+
+func testIdempotency(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+	other := createUser(t, s, "bob")
+
+	record := model.IdempotencyRecord{
+		UserID:      user.ID,
+		Key:         "key-1",
+		Fingerprint: "fp-1",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+
+	_, created, err := s.ReserveIdempotencyKey(ctx, record)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	existing, created, err := s.ReserveIdempotencyKey(ctx, record)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, "fp-1", existing.Fingerprint)
+	require.Zero(t, existing.StatusCode, "response is not saved yet")
+
+	// ключи разных пользователей не пересекаются
+	_, created, err = s.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{
+		UserID: other.ID, Key: "key-1", Fingerprint: "fp-2", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	record.StatusCode = 200
+	record.ContentType = "application/json"
+	record.Body = []byte(`{"ok":true}`)
+	require.NoError(t, s.SaveIdempotencyResponse(ctx, record))
+
+	existing, created, err = s.ReserveIdempotencyKey(ctx, record)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, 200, existing.StatusCode)
+	require.Equal(t, "application/json", existing.ContentType)
+	require.Equal(t, []byte(`{"ok":true}`), existing.Body)
+
+	require.NoError(t, s.DeleteIdempotencyKey(ctx, user.ID, "key-1"))
+	_, created, err = s.ReserveIdempotencyKey(ctx, record)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	// просроченный ключ можно занять заново
+	expired := model.IdempotencyRecord{
+		UserID: user.ID, Key: "key-2", Fingerprint: "fp-1", ExpiresAt: time.Now().Add(-time.Minute)}
+	_, created, err = s.ReserveIdempotencyKey(ctx, expired)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	_, created, err = s.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{
+		UserID: user.ID, Key: "key-2", Fingerprint: "fp-3", ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.True(t, created)
+
+	// незавершённый запрос держит ключ только на время аренды, дальше ключ занимает следующий
+	lease := model.IdempotencyRecord{
+		UserID: user.ID, Key: "key-3", Fingerprint: "fp-1", ExpiresAt: time.Now().Add(100 * time.Millisecond)}
+	_, created, err = s.ReserveIdempotencyKey(ctx, lease)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	_, created, err = s.ReserveIdempotencyKey(ctx, lease)
+	require.NoError(t, err)
+	require.False(t, created)
+
+	time.Sleep(200 * time.Millisecond)
+	lease.ExpiresAt = time.Now().Add(100 * time.Millisecond)
+	_, created, err = s.ReserveIdempotencyKey(ctx, lease)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	// сохранённый ответ продлевает запись и не перезаписывается опоздавшим запросом
+	lease.StatusCode = 200
+	lease.ExpiresAt = time.Now().Add(time.Hour)
+	require.NoError(t, s.SaveIdempotencyResponse(ctx, lease))
+
+	late := lease
+	late.StatusCode = 409
+	require.NoError(t, s.SaveIdempotencyResponse(ctx, late))
+
+	time.Sleep(200 * time.Millisecond)
+	existing, created, err = s.ReserveIdempotencyKey(ctx, lease)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, 200, existing.StatusCode)
+}
+
+func testSessions(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := createUser(t, s, "alice")
+	other := createUser(t, s, "bob")
+
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, s.CreateRefreshToken(ctx, model.RefreshToken{Hash: "r1", UserID: user.ID, FamilyID: "r1", ExpiresAt: expiresAt}))
+
+	_, err := s.RotateRefreshToken(ctx, "unknown", model.RefreshToken{Hash: "x", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	userID, err := s.RotateRefreshToken(ctx, "r1", model.RefreshToken{Hash: "r2", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.Equal(t, user.ID, userID)
+
+	userID, err = s.RotateRefreshToken(ctx, "r2", model.RefreshToken{Hash: "r3", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	require.Equal(t, user.ID, userID)
+
+	// повтор погашенного токена отзывает всё семейство, включая последний выданный
+	_, err = s.RotateRefreshToken(ctx, "r1", model.RefreshToken{Hash: "r4", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrRefreshTokenReused)
+	_, err = s.RotateRefreshToken(ctx, "r3", model.RefreshToken{Hash: "r5", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrRefreshTokenReused)
+
+	require.NoError(t, s.CreateRefreshToken(ctx, model.RefreshToken{Hash: "old", UserID: user.ID, FamilyID: "old", ExpiresAt: time.Now().Add(-time.Minute)}))
+	_, err = s.RotateRefreshToken(ctx, "old", model.RefreshToken{Hash: "r6", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	// выход отзывает только свои токены
+	require.NoError(t, s.CreateRefreshToken(ctx, model.RefreshToken{Hash: "b1", UserID: other.ID, FamilyID: "b1", ExpiresAt: expiresAt}))
+	require.NoError(t, s.RevokeRefreshToken(ctx, user.ID, "b1"))
+	_, err = s.RotateRefreshToken(ctx, "b1", model.RefreshToken{Hash: "b2", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+
+	require.NoError(t, s.RevokeRefreshToken(ctx, other.ID, "b2"))
+	_, err = s.RotateRefreshToken(ctx, "b2", model.RefreshToken{Hash: "b3", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrRefreshTokenReused)
+
+	// завершение всех сессий пользователя не трогает чужие
+	require.NoError(t, s.CreateRefreshToken(ctx, model.RefreshToken{Hash: "a1", UserID: user.ID, FamilyID: "a1", ExpiresAt: expiresAt}))
+	require.NoError(t, s.CreateRefreshToken(ctx, model.RefreshToken{Hash: "a2", UserID: user.ID, FamilyID: "a2", ExpiresAt: expiresAt}))
+	require.NoError(t, s.CreateRefreshToken(ctx, model.RefreshToken{Hash: "c1", UserID: other.ID, FamilyID: "c1", ExpiresAt: expiresAt}))
+	require.NoError(t, s.RevokeUserSessions(ctx, user.ID))
+	_, err = s.RotateRefreshToken(ctx, "a1", model.RefreshToken{Hash: "a3", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrRefreshTokenReused)
+	_, err = s.RotateRefreshToken(ctx, "a2", model.RefreshToken{Hash: "a4", ExpiresAt: expiresAt})
+	require.ErrorIs(t, err, errs.ErrRefreshTokenReused)
+	_, err = s.RotateRefreshToken(ctx, "c1", model.RefreshToken{Hash: "c2", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+
+	revoked, err := s.IsTokenRevoked(ctx, "jti-1")
+	require.NoError(t, err)
+	require.False(t, revoked)
+
+	require.NoError(t, s.RevokeToken(ctx, "jti-1", expiresAt))
+	require.NoError(t, s.RevokeToken(ctx, "jti-1", expiresAt))
+
+	revoked, err = s.IsTokenRevoked(ctx, "jti-1")
+	require.NoError(t, err)
+	require.True(t, revoked)
+}
+
+func testLoginAttempts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	limit := model.LoginLimit{MaxFailures: 3, Lockout: time.Hour}
+	now := time.Now()
+
+	for i := 1; i <= 2; i++ {
+		attempts, admitted, err := s.RecordLoginAttempt(ctx, "login:alice", limit, now)
+		require.NoError(t, err)
+		require.True(t, admitted)
+		require.Equal(t, i, attempts.Failures)
+		require.True(t, attempts.LockedUntil.IsZero())
+	}
+
+	// на третьей попытке ключ блокируется, но сама она ещё проходит
+	attempts, admitted, err := s.RecordLoginAttempt(ctx, "login:alice", limit, now)
+	require.NoError(t, err)
+	require.True(t, admitted)
+	require.Equal(t, 3, attempts.Failures)
+	require.WithinDuration(t, now.Add(time.Hour), attempts.LockedUntil, time.Second)
+	lockedUntil := attempts.LockedUntil
+
+	// заблокированный ключ попытки не принимает и не считает
+	attempts, admitted, err = s.RecordLoginAttempt(ctx, "login:alice", limit, now)
+	require.NoError(t, err)
+	require.False(t, admitted)
+	require.Equal(t, 3, attempts.Failures)
+	require.WithinDuration(t, lockedUntil, attempts.LockedUntil, time.Second)
+
+	// после блокировки ключ снова принимает попытки, и счёт начинается заново
+	attempts, admitted, err = s.RecordLoginAttempt(ctx, "login:alice", limit, lockedUntil.Add(time.Second))
+	require.NoError(t, err)
+	require.True(t, admitted)
+	require.Equal(t, 1, attempts.Failures)
+
+	// ключи считаются независимо
+	attempts, admitted, err = s.RecordLoginAttempt(ctx, "ip:10.0.0.1", limit, now)
+	require.NoError(t, err)
+	require.True(t, admitted)
+	require.Equal(t, 1, attempts.Failures)
+
+	require.NoError(t, s.ResetLoginAttempts(ctx, "login:alice"))
+	attempts, admitted, err = s.RecordLoginAttempt(ctx, "login:alice", limit, now)
+	require.NoError(t, err)
+	require.True(t, admitted)
+	require.Equal(t, 1, attempts.Failures)
+
+	// удачная попытка снимается со счёта
+	require.NoError(t, s.ForgiveLoginAttempt(ctx, "ip:10.0.0.1"))
+	require.NoError(t, s.ForgiveLoginAttempt(ctx, "ip:10.0.0.1"))
+	attempts, _, err = s.RecordLoginAttempt(ctx, "ip:10.0.0.1", limit, now)
+	require.NoError(t, err)
+	require.Equal(t, 1, attempts.Failures)
+
+	// пауза растёт со второй неудачи
+	delayed := model.LoginLimit{MaxFailures: 10, Lockout: time.Hour, Delay: time.Minute}
+	_, _, err = s.RecordLoginAttempt(ctx, "login:bob", delayed, now)
+	require.NoError(t, err)
+	attempts, admitted, err = s.RecordLoginAttempt(ctx, "login:bob", delayed, now)
+	require.NoError(t, err)
+	require.True(t, admitted)
+	require.WithinDuration(t, now.Add(time.Minute), attempts.LockedUntil, time.Second)
+
+	// после окна без попыток счёт начинается заново
+	_, _, err = s.RecordLoginAttempt(ctx, "login:carol", limit, now)
+	require.NoError(t, err)
+	attempts, _, err = s.RecordLoginAttempt(ctx, "login:carol", limit, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, attempts.Failures)
+}
+
+// параллельные попытки не должны проскочить блокировку, засчитавшись одновременно
+func testConcurrentLoginAttempts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	limit := model.LoginLimit{MaxFailures: 5, Lockout: time.Hour}
+
+	const workers = 20
+	var admittedCount atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, admitted, err := s.RecordLoginAttempt(ctx, "login:alice", limit, time.Now())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if admitted {
+				admittedCount.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.EqualValues(t, limit.MaxFailures, admittedCount.Load())
+}
+
+func testDeleteStaleLoginAttempts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	limit := model.LoginLimit{MaxFailures: 2, Lockout: time.Hour}
+	now := time.Now()
+
+	// давно не обновлявшиеся ключи
+	for _, key := range []string{"ip:10.0.0.1", "ip:10.0.0.2", "ip:10.0.0.3"} {
+		_, _, err := s.RecordLoginAttempt(ctx, key, limit, now.Add(-2*time.Hour))
+		require.NoError(t, err)
+	}
+	// свежий ключ
+	_, _, err := s.RecordLoginAttempt(ctx, "login:alice", limit, now)
+	require.NoError(t, err)
+	// давно не обновлялся, но ещё заблокирован
+	long := model.LoginLimit{MaxFailures: 1, Lockout: 3 * time.Hour}
+	_, _, err = s.RecordLoginAttempt(ctx, "login:bob", long, now.Add(-2*time.Hour))
+	require.NoError(t, err)
+
+	before := now.Add(-time.Hour)
+	deleted, err := s.DeleteStaleLoginAttempts(ctx, before, now, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	deleted, err = s.DeleteStaleLoginAttempts(ctx, before, now, 2)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	deleted, err = s.DeleteStaleLoginAttempts(ctx, before, now, 2)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	// свежий и заблокированный ключи остались со своим счётом
+	attempts, admitted, err := s.RecordLoginAttempt(ctx, "login:alice", limit, now)
+	require.NoError(t, err)
+	require.True(t, admitted)
+	require.Equal(t, 2, attempts.Failures)
+
+	_, admitted, err = s.RecordLoginAttempt(ctx, "login:bob", long, now)
+	require.NoError(t, err)
+	require.False(t, admitted)
+}
@@ -0,0 +1,90 @@
+// Package workerpool — пул воркеров фиксированного размера с отсевом задач, которые уже в работе.
+package workerpool
+
+import (
+	"context"
+	"sync"
+)
+
+type Pool[T any] struct {
+	size   int
+	key    func(T) string
+	handle func(context.Context, T)
+
+	jobs chan T
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// New создаёт пул из size воркеров; задачи с одинаковым key не выполняются одновременно
+func New[T any](size int, key func(T) string, handle func(context.Context, T)) *Pool[T] {
+	return &Pool[T]{
+		size:     max(size, 1),
+		key:      key,
+		handle:   handle,
+		jobs:     make(chan T),
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+// Start запускает воркеры. Начатая задача доводится до конца и после отмены ctx,
+// поэтому handle должен сам ограничивать своё время
+func (p *Pool[T]) Start(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for item := range p.jobs {
+				p.handle(ctx, item)
+				p.forget(p.key(item))
+			}
+		}()
+	}
+}
+
+// Submit ждёт свободного воркера и отдаёт ему задачу. Возвращает false без ошибки,
+// если задача с тем же ключом уже выполняется
+func (p *Pool[T]) Submit(ctx context.Context, item T) (bool, error) {
+	key := p.key(item)
+
+	p.mu.Lock()
+	if _, ok := p.inFlight[key]; ok {
+		p.mu.Unlock()
+		return false, nil
+	}
+	p.inFlight[key] = struct{}{}
+	p.mu.Unlock()
+
+	select {
+	case p.jobs <- item:
+		return true, nil
+	case <-ctx.Done():
+		p.forget(key)
+		return false, ctx.Err()
+	}
+}
+
+// Free — сколько воркеров сейчас без задачи
+func (p *Pool[T]) Free() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.size - len(p.inFlight)
+}
+
+// Close дожидается завершения начатых задач. Вызывать после того, как Submit больше не вызывается
+func (p *Pool[T]) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+func (p *Pool[T]) forget(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inFlight, key)
+}
@@ -0,0 +1,95 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func identity(s string) string { return s }
+
+func TestPool_Deduplicates(t *testing.T) {
+	release := make(chan struct{})
+	var handled atomic.Int32
+
+	p := New(2, identity, func(ctx context.Context, s string) {
+		handled.Add(1)
+		<-release
+	})
+	p.Start(context.Background())
+
+	ctx := context.Background()
+	if ok, err := p.Submit(ctx, "a"); !ok || err != nil {
+		t.Fatalf("expected submit, got %v %v", ok, err)
+	}
+	if ok, err := p.Submit(ctx, "a"); ok || err != nil {
+		t.Fatalf("expected duplicate to be skipped, got %v %v", ok, err)
+	}
+	if free := p.Free(); free != 1 {
+		t.Errorf("expected 1 free worker, got %d", free)
+	}
+
+	close(release)
+	p.Close()
+
+	if handled.Load() != 1 {
+		t.Errorf("expected one handled job, got %d", handled.Load())
+	}
+}
+
+func TestPool_BackPressure(t *testing.T) {
+	release := make(chan struct{})
+	p := New(1, identity, func(ctx context.Context, s string) {
+		<-release
+	})
+	p.Start(context.Background())
+	defer p.Close()
+
+	if _, err := p.Submit(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// единственный воркер занят — вторая задача ждёт, а не теряется
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := p.Submit(ctx, "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected submit to block, got %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.Submit(context.Background(), "b")
+		close(done)
+	}()
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("submit did not proceed after worker became free")
+	}
+}
+
+func TestPool_CloseDrains(t *testing.T) {
+	var finished atomic.Bool
+
+	p := New(1, identity, func(ctx context.Context, s string) {
+		time.Sleep(50 * time.Millisecond)
+		if ctx.Err() == nil {
+			finished.Store(true)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	p.Submit(ctx, "a")
+	cancel()
+
+	p.Close()
+
+	if !finished.Load() {
+		t.Error("expected in-flight job to finish before Close returns")
+	}
+}